@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// In ABAC mode the authorizer passes the caller's attributes as STS session tags instead of an inline session policy.
+// The AuthorizerAccessRole policy then scopes access with aws:PrincipalTag conditions, so it stays static and the
+// request never approaches the packed policy size limit.
+
+// Session tag keys set in ABAC mode
+const (
+	tenantIDTag = "tenantId"
+	userRoleTag = "userRole"
+	tierTag     = "tier"
+	userIDTag   = "userId"
+)
+
+// abacServiceIdentifiers lists the service identifiers that use ABAC (ABAC_SERVICE_IDENTIFIERS, none when unset)
+var abacServiceIdentifiers = abacServiceIdentifiersFromEnv()
+
+// abacSupportedServiceIdentifiers are those the AuthorizerAccessRoleAbacPolicy grants every role access to. Dedicated
+// tables are named in the tenant registry, which a tag condition cannot express, so they keep using session policies.
+var abacSupportedServiceIdentifiers = map[string]bool{
+	ServiceIdentifiers.SHARED_SERVICES: true,
+}
+
+func abacServiceIdentifiersFromEnv() []string {
+	var serviceIdentifiers []string
+	for _, serviceIdentifier := range envList("ABAC_SERVICE_IDENTIFIERS") {
+		if !abacSupportedServiceIdentifiers[serviceIdentifier] {
+			log.Printf("Ignoring unsupported service identifier %q in ABAC_SERVICE_IDENTIFIERS", serviceIdentifier)
+			continue
+		}
+		serviceIdentifiers = append(serviceIdentifiers, serviceIdentifier)
+	}
+	return serviceIdentifiers
+}
+
+// abacTransitiveTagKeys are the tags that persist when the assumed role is used to chain into further roles
+var abacTransitiveTagKeys = envListOrDefault("ABAC_TRANSITIVE_TAG_KEYS", tenantIDTag, userRoleTag)
+
+// useABAC reports whether the session for the target role is scoped by session tags. Any other role would grant its
+// own permissions unscoped, so it always gets a session policy.
+func useABAC(serviceIdentifier string, target targetRole) bool {
+	return target.ABAC && isABACEnabled(serviceIdentifier)
+}
+
+func isABACEnabled(serviceIdentifier string) bool {
+	for _, candidate := range abacServiceIdentifiers {
+		if candidate == serviceIdentifier {
+			return true
+		}
+	}
+	return false
+}
+
+// abacSessionTags returns the session tags describing the caller. The AuthorizerAccessRoleAbacPolicy substitutes them
+// into dynamodb:LeadingKeys patterns, so they are held to the same rules as policy template values.
+func abacSessionTags(tenantID, userRole, tier, userID string) (map[string]string, error) {
+	tags := map[string]string{
+		tenantIDTag: tenantID,
+		userRoleTag: userRole,
+		tierTag:     tier,
+		userIDTag:   userID,
+	}
+
+	for key, value := range tags {
+		if err := checkPolicyValue(value); err != nil {
+			return nil, fmt.Errorf("invalid value for session tag %s: %w", key, err)
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestABACServiceIdentifiersFromEnv(t *testing.T) {
+	t.Setenv("ABAC_SERVICE_IDENTIFIERS", "SharedServices,DedicatedTenantServices,Unknown")
+
+	got := abacServiceIdentifiersFromEnv()
+	if len(got) != 1 || got[0] != ServiceIdentifiers.SHARED_SERVICES {
+		t.Fatalf("abacServiceIdentifiersFromEnv() = %v, want [SharedServices]", got)
+	}
+}
+
+func TestABACSessionTags(t *testing.T) {
+	tags, err := abacSessionTags("t1", "TenantUser", "Basic", "u1")
+	if err != nil {
+		t.Fatalf("abacSessionTags() error = %v", err)
+	}
+	want := map[string]string{tenantIDTag: "t1", userRoleTag: "TenantUser", tierTag: "Basic", userIDTag: "u1"}
+	for key, value := range want {
+		if tags[key] != value {
+			t.Fatalf("abacSessionTags() = %v, want %v", tags, want)
+		}
+	}
+
+	for _, tt := range []struct{ tenantID, userID string }{
+		{"t1#USER#u2", "u1"},
+		{"t1", "u1#x"},
+		{"t*", "u1"},
+		{"t?", "u1"},
+		{"t1", "${aws:username}"},
+	} {
+		if _, err := abacSessionTags(tt.tenantID, "TenantUser", "Basic", tt.userID); err == nil {
+			t.Errorf("abacSessionTags(%q, %q) succeeded, want an error", tt.tenantID, tt.userID)
+		}
+	}
+}
+
+func TestUseABACOnlyForDefaultRole(t *testing.T) {
+	const accountID = "123456789012"
+
+	defer func(identifiers []string, tiers, models map[string]string) {
+		abacServiceIdentifiers, tierRoleArns, deploymentModelRoleArns = identifiers, tiers, models
+	}(abacServiceIdentifiers, tierRoleArns, deploymentModelRoleArns)
+
+	abacServiceIdentifiers = []string{ServiceIdentifiers.SHARED_SERVICES}
+	tierRoleArns = map[string]string{"Premier": "arn:aws:iam::123456789012:role/PremierAccessRole"}
+	deploymentModelRoleArns = map[string]string{DeploymentModels.DEDICATED: "arn:aws:iam::123456789012:role/DedicatedAccessRole"}
+
+	tests := []struct {
+		name     string
+		tenant   TenantDetails
+		wantABAC bool
+	}{
+		{name: "default role", tenant: TenantDetails{TenantID: "t1", Tier: "Basic"}, wantABAC: true},
+		{name: "tier role", tenant: TenantDetails{TenantID: "t1", Tier: "Premier"}},
+		{name: "deployment model role", tenant: TenantDetails{TenantID: "t1", Tier: "Basic", DeploymentModel: DeploymentModels.DEDICATED}},
+		{name: "tenant role", tenant: TenantDetails{TenantID: "t1", Tier: "Basic", RoleArn: "arn:aws:iam::123456789012:role/AcmeRole"}},
+		{name: "tenant role naming the default role", tenant: TenantDetails{TenantID: "t1", Tier: "Premier", RoleArn: "arn:aws:iam::123456789012:role/AuthorizerAccessRole"}, wantABAC: true},
+		{name: "tenant account", tenant: TenantDetails{TenantID: "t1", Tier: "Basic", AccountID: "999999999999", ExternalID: "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := resolveTargetRole(&tt.tenant, accountID)
+			if err != nil {
+				t.Fatalf("resolveTargetRole() error = %v", err)
+			}
+
+			if got := useABAC(ServiceIdentifiers.SHARED_SERVICES, target); got != tt.wantABAC {
+				t.Fatalf("useABAC() with role %s = %v, want %v", target.Arn, got, tt.wantABAC)
+			}
+		})
+	}
+}
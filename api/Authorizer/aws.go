@@ -0,0 +1,23 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var (
+	sharedSessionOnce sync.Once
+	sharedSess        *session.Session
+	sharedSessErr     error
+)
+
+// sharedSession returns the AWS session reused by every invocation handled by a warm Lambda container
+func sharedSession() (*session.Session, error) {
+	sharedSessionOnce.Do(func() {
+		sharedSess, sharedSessErr = session.NewSessionWithOptions(session.Options{
+			SharedConfigState: session.SharedConfigEnable,
+		})
+	})
+	return sharedSess, sharedSessErr
+}
@@ -0,0 +1,11 @@
+package main
+
+// authorizerResultTTL is how long API Gateway caches authorizer decisions, keyed on the Authorization header
+// (AUTHORIZER_RESULT_TTL, matching AuthorizerResultTtlInSeconds; 0 disables caching). While caching is enabled the
+// authorizer only returns cache-safe responses: the execute-api policy covers the whole stage, the context holds no
+// per-request fields, and credentials remain valid for at least the TTL.
+var authorizerResultTTL = envDuration("AUTHORIZER_RESULT_TTL", 0)
+
+func isCacheSafeMode() bool {
+	return authorizerResultTTL > 0
+}
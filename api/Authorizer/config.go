@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// envString returns the value of the environment variable key, or fallback when it is unset or empty.
+func envString(key, fallback string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envDuration parses the environment variable key as a Go duration (e.g. "90s", "1h").
+// Invalid values are logged and the fallback is used instead.
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+
+	return duration
+}
+
+// envList splits the environment variable key on commas, dropping empty entries.
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// envMap parses the environment variable key as comma-separated key=value pairs (e.g. "eu1=a,us1=b").
+// Malformed pairs are logged and skipped.
+func envMap(key string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range envList(key) {
+		name, value, found := strings.Cut(pair, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !found || name == "" || value == "" {
+			log.Printf("Ignoring malformed entry %q in %s", pair, key)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+// envListOrDefault is envList with fallback values for when the variable is unset or empty.
+func envListOrDefault(key string, fallback ...string) []string {
+	if values := envList(key); len(values) > 0 {
+		return values
+	}
+	return fallback
+}
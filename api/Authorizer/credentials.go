@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+	"golang.org/x/sync/singleflight"
+)
+
+// credentials is shared by every invocation handled by a warm Lambda container. Credentials are never handed out
+// with less than the authorizer result TTL left, as API Gateway may keep returning them for that long.
+var credentials = newCredentialCache(maxDuration(envDuration("CREDENTIAL_REFRESH_MARGIN", 5*time.Minute), authorizerResultTTL))
+
+// credentialCache reuses STS credentials for requests with the same tenant, role, service identifier and session policy.
+// Credentials are refreshed once they are within refreshMargin of expiring, and concurrent requests for the same key
+// share a single AssumeRole call.
+type credentialCache struct {
+	mu            sync.Mutex
+	entries       map[string]*sts.Credentials
+	refreshMargin time.Duration
+	group         singleflight.Group
+	now           func() time.Time
+}
+
+func newCredentialCache(refreshMargin time.Duration) *credentialCache {
+	return &credentialCache{
+		entries:       map[string]*sts.Credentials{},
+		refreshMargin: refreshMargin,
+		now:           time.Now,
+	}
+}
+
+// roleSession is the session requested from STS: an inline session policy, or session tags in ABAC mode
+type roleSession struct {
+	RoleArn           string
+	ExternalID        string
+	SessionName       string
+	Policy            string
+	Tags              map[string]string
+	TransitiveTagKeys []string
+	// SourceIdentity attributes every call made with the credentials to the end user in CloudTrail
+	SourceIdentity string
+	Duration       time.Duration
+}
+
+// STS limits for role session names, source identities and session durations. The Lambda itself runs with
+// assumed-role credentials, so every AssumeRole it makes is role chaining, which STS limits to one hour whatever
+// the role's MaxSessionDuration.
+const (
+	maxSTSNameLength     = 64
+	minSessionDuration   = 15 * time.Minute
+	maxSessionDuration   = time.Hour
+	sessionNameHashChars = 16
+)
+
+var (
+	sessionDurationMin  = minDuration(maxDuration(maxDuration(envDuration("STS_MIN_SESSION_DURATION", minSessionDuration), minSessionDuration), authorizerResultTTL), maxSessionDuration)
+	sessionDurationMax  = maxDuration(minDuration(envDuration("STS_MAX_SESSION_DURATION", maxSessionDuration), maxSessionDuration), sessionDurationMin)
+	sourceIdentityClaim = envString("SOURCE_IDENTITY_CLAIM", "sub")
+	invalidSTSNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+)
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// sanitizeSTSName replaces the characters STS does not accept in role session names and source identities
+func sanitizeSTSName(value string) string {
+	return invalidSTSNameChars.ReplaceAllString(value, "_")
+}
+
+// roleSessionName returns "<tenantId>-<requestId>" when it fits in 64 characters. Longer names keep as much of the
+// tenant ID as fits, followed by a hash of both values so sessions remain distinguishable.
+func roleSessionName(tenantID, requestID string) string {
+	name := sanitizeSTSName(tenantID) + "-" + sanitizeSTSName(requestID)
+	if len(name) <= maxSTSNameLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(tenantID + "|" + requestID))
+	suffix := "-" + hex.EncodeToString(hash[:])[:sessionNameHashChars]
+	tenant := sanitizeSTSName(tenantID)
+	if len(tenant) > maxSTSNameLength-len(suffix) {
+		tenant = tenant[:maxSTSNameLength-len(suffix)]
+	}
+	return tenant + suffix
+}
+
+// sourceIdentity returns the claim configured by SOURCE_IDENTITY_CLAIM (sub or email), made acceptable to STS
+func sourceIdentity(claims CognitoJWTClaim) string {
+	value := claims.Subject
+	if sourceIdentityClaim == "email" && claims.Email != "" {
+		value = claims.Email
+	}
+
+	value = sanitizeSTSName(value)
+	if len(value) > maxSTSNameLength {
+		value = value[:maxSTSNameLength]
+	}
+	return value
+}
+
+// sessionDuration matches the credentials to the remaining lifetime of the token, clamped to
+// STS_MIN_SESSION_DURATION (or the authorizer result TTL, if longer) and STS_MAX_SESSION_DURATION
+func sessionDuration(expiresAt, now time.Time) time.Duration {
+	remaining := expiresAt.Sub(now).Truncate(time.Second)
+	if remaining < sessionDurationMin {
+		return sessionDurationMin
+	}
+	if remaining > sessionDurationMax {
+		return sessionDurationMax
+	}
+	return remaining
+}
+
+// credentialKey identifies the credentials for a tenant, role, service identifier, target role, source identity and
+// session policy or tags. Credentials carry the user's source identity, so they are never shared between users.
+func credentialKey(tenantID, userRole, serviceIdentifier string, request roleSession) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Policy))
+	for _, key := range sortedKeys(request.Tags) {
+		fmt.Fprintf(hash, "\x00%s=%s", key, request.Tags[key])
+	}
+	return strings.Join([]string{tenantID, userRole, serviceIdentifier, request.RoleArn, request.SourceIdentity, hex.EncodeToString(hash.Sum(nil))}, "|")
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the cached credentials for key, calling assume when there are none or they are about to expire
+func (c *credentialCache) Get(key string, assume func() (*sts.Credentials, error)) (*sts.Credentials, error) {
+	if creds, ok := c.lookup(key); ok {
+		return creds, nil
+	}
+
+	result, err, _ := c.group.Do(key, func() (interface{}, error) {
+		// Another request may have stored the credentials while this one waited
+		if creds, ok := c.lookup(key); ok {
+			return creds, nil
+		}
+
+		creds, err := assume()
+		if err != nil {
+			return nil, err
+		}
+
+		c.store(key, creds)
+		return creds, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*sts.Credentials), nil
+}
+
+func (c *credentialCache) lookup(key string) (*sts.Credentials, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	creds, ok := c.entries[key]
+	if !ok || !c.usable(creds) {
+		return nil, false
+	}
+	return creds, true
+}
+
+func (c *credentialCache) store(key string, creds *sts.Credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired entries so the cache stays bounded by the number of active tenants
+	for k, cached := range c.entries {
+		if !c.usable(cached) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = creds
+}
+
+func (c *credentialCache) usable(creds *sts.Credentials) bool {
+	return creds.Expiration != nil && c.now().Add(c.refreshMargin).Before(*creds.Expiration)
+}
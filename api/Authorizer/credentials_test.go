@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func testCredentials(accessKeyID string, expiration time.Time) *sts.Credentials {
+	return &sts.Credentials{
+		AccessKeyId:     aws.String(accessKeyID),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(expiration),
+	}
+}
+
+func TestCredentialCacheSingleFlight(t *testing.T) {
+	cache := newCredentialCache(5 * time.Minute)
+	cache.now = func() time.Time { return testNow }
+
+	const callers = 20
+	var calls int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assume := func() (*sts.Credentials, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return testCredentials("cold", testNow.Add(time.Hour)), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*sts.Credentials, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = cache.Get("key", assume)
+		}(i)
+	}
+
+	// Hold the first AssumeRole call open while the other callers arrive
+	<-started
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&calls); calls != 1 {
+		t.Fatalf("assume called %d times, want 1", calls)
+	}
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: Get() error = %v", i, errs[i])
+		}
+		if results[i] != results[0] {
+			t.Fatalf("caller %d: got different credentials", i)
+		}
+	}
+}
+
+func TestCredentialCacheRefreshMargin(t *testing.T) {
+	tests := []struct {
+		name       string
+		expiration *time.Time
+		wantAssume bool
+	}{
+		{name: "outside margin reused", expiration: at(10 * time.Minute)},
+		{name: "just outside margin reused", expiration: at(5*time.Minute + time.Second)},
+		{name: "at margin refreshed", expiration: at(5 * time.Minute), wantAssume: true},
+		{name: "inside margin refreshed", expiration: at(4 * time.Minute), wantAssume: true},
+		{name: "expired refreshed", expiration: at(-time.Minute), wantAssume: true},
+		{name: "no expiration refreshed", wantAssume: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newCredentialCache(5 * time.Minute)
+			cache.now = func() time.Time { return testNow }
+
+			cached := testCredentials("cached", testNow)
+			cached.Expiration = tt.expiration
+			cache.entries["key"] = cached
+
+			calls := 0
+			creds, err := cache.Get("key", func() (*sts.Credentials, error) {
+				calls++
+				return testCredentials("fresh", testNow.Add(time.Hour)), nil
+			})
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+
+			want := "cached"
+			if tt.wantAssume {
+				want = "fresh"
+			}
+			if (calls == 1) != tt.wantAssume || *creds.AccessKeyId != want {
+				t.Fatalf("Get() = %s after %d assume calls, want %s", *creds.AccessKeyId, calls, want)
+			}
+
+			// Refreshed credentials replace the cached ones
+			if again, _ := cache.Get("key", nil); again != creds {
+				t.Fatalf("second Get() returned different credentials")
+			}
+		})
+	}
+}
+
+func TestCredentialCacheDoesNotCacheErrors(t *testing.T) {
+	cache := newCredentialCache(5 * time.Minute)
+	cache.now = func() time.Time { return testNow }
+
+	errAssume := errors.New("AccessDenied")
+	calls := 0
+	assume := func() (*sts.Credentials, error) {
+		calls++
+		if calls == 1 {
+			return nil, errAssume
+		}
+		return testCredentials("retried", testNow.Add(time.Hour)), nil
+	}
+
+	if _, err := cache.Get("key", assume); !errors.Is(err, errAssume) {
+		t.Fatalf("first Get() error = %v, want %v", err, errAssume)
+	}
+	creds, err := cache.Get("key", assume)
+	if err != nil || *creds.AccessKeyId != "retried" {
+		t.Fatalf("second Get() = %v, %v, want retried credentials", creds, err)
+	}
+}
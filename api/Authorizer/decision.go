@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// errUnauthorized is the sentinel error API Gateway turns into a 401 Unauthorized response.
+// Any other error returned by the Lambda surfaces as a 500, so it is reserved for internal failures.
+var errUnauthorized = errors.New("Unauthorized")
+
+// Rejection is an authorization failure caused by the caller rather than by the authorizer itself
+type Rejection struct {
+	// Status is http.StatusUnauthorized for missing or invalid tokens, http.StatusForbidden for valid tokens that are not allowed in
+	Status int
+	Reason string
+}
+
+func (r Rejection) Error() string {
+	return r.Reason
+}
+
+// unauthorized rejects a request whose credentials could not be validated
+func unauthorized(reason string) error {
+	return Rejection{Status: http.StatusUnauthorized, Reason: reason}
+}
+
+// forbidden rejects a request from a caller that was authenticated but may not proceed
+func forbidden(reason string) error {
+	return Rejection{Status: http.StatusForbidden, Reason: reason}
+}
+
+// newResponse returns an authorizer response with an empty policy and the default CORS context
+func newResponse(principalID string) Response {
+	return Response{
+		PrincipalID: principalID,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version:   "2012-10-17",
+			Statement: []events.IAMPolicyStatement{},
+		},
+		Context: map[string]interface{}{
+			"Access-Control-Allow-Origin":  "*",
+			"Access-Control-Allow-Methods": "*",
+			"Access-Control-Allow-Headers": "*",
+			"Content-Type":                 "*/*",
+		},
+	}
+}
+
+// denyResponse returns an explicit Deny for methodArn, which API Gateway turns into a 403 Forbidden.
+// The reason is available to gateway responses as $context.authorizer.reason.
+func denyResponse(principalID, methodArn, reason string) Response {
+	response := newResponse(principalID)
+	response.PolicyDocument.Statement = append(response.PolicyDocument.Statement, events.IAMPolicyStatement{
+		Action:   []string{"execute-api:Invoke"},
+		Effect:   "Deny",
+		Resource: []string{methodArn},
+	})
+	response.Context["reason"] = reason
+	return response
+}
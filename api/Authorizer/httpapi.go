@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// HTTPAPIResponseFormats are the HTTP API authorizer response formats. The format must match the authorizer's
+// EnableSimpleResponses setting.
+var HTTPAPIResponseFormats = struct {
+	SIMPLE string
+	IAM    string
+}{
+	"simple",
+	"iam",
+}
+
+// errSimpleResponseCached reports simple responses configured while authorizer results are cached
+var errSimpleResponseCached = errors.New("internal authorizer error: simple responses cannot be cached")
+
+// httpAPIResponseFormat is the response format of the HTTP API handler (HTTP_API_RESPONSE_FORMAT, simple or iam)
+var httpAPIResponseFormat = envString("HTTP_API_RESPONSE_FORMAT", HTTPAPIResponseFormats.SIMPLE)
+
+// HTTPAPIHandler authorizes HTTP API (API Gateway v2) requests with payload format 2.0. HTTP APIs only answer 401
+// when the identity source is missing, so every rejection is returned as a denial carrying its reason.
+func HTTPAPIHandler(ctx context.Context, event events.APIGatewayV2CustomAuthorizerV2Request) (interface{}, error) {
+	simple := httpAPIResponseFormat != HTTPAPIResponseFormats.IAM
+
+	// A cached simple response would allow every route to any caller whose token once passed the route check
+	if simple && isCacheSafeMode() {
+		log.Printf("Request ID: %s Simple responses cannot be cached: set HTTP_API_RESPONSE_FORMAT=iam or AUTHORIZER_RESULT_TTL=0", event.RequestContext.RequestID)
+		return nil, errSimpleResponseCached
+	}
+
+	request := authorizerRequest{
+		RequestID: event.RequestContext.RequestID,
+		APIID:     event.RequestContext.APIID,
+		MethodArn: event.RouteArn,
+		Headers:   event.Headers,
+		Cookies:   event.Cookies,
+		// A simple response has no policy to carry the route permissions
+		CheckRoutes: simple,
+	}
+
+	policy, rejection, err := evaluate(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("internal authorizer error: %w", err)
+	}
+
+	if simple {
+		if rejection != nil {
+			return events.APIGatewayV2CustomAuthorizerSimpleResponse{
+				IsAuthorized: false,
+				Context:      map[string]interface{}{"reason": rejection.Reason},
+			}, nil
+		}
+		return events.APIGatewayV2CustomAuthorizerSimpleResponse{
+			IsAuthorized: true,
+			Context:      policy.Context,
+		}, nil
+	}
+
+	if rejection != nil {
+		policy = denyResponse("user", request.MethodArn, rejection.Reason)
+	}
+	return events.APIGatewayV2CustomAuthorizerIAMPolicyResponse{
+		PrincipalID:    policy.PrincipalID,
+		PolicyDocument: policy.PolicyDocument,
+		Context:        policy.Context,
+	}, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestHTTPAPIHandlerRefusesCachedSimpleResponses(t *testing.T) {
+	defer func(ttl time.Duration, format string) {
+		authorizerResultTTL, httpAPIResponseFormat = ttl, format
+	}(authorizerResultTTL, httpAPIResponseFormat)
+
+	authorizerResultTTL = time.Minute
+	httpAPIResponseFormat = HTTPAPIResponseFormats.SIMPLE
+
+	response, err := HTTPAPIHandler(context.Background(), events.APIGatewayV2CustomAuthorizerV2Request{})
+	if !errors.Is(err, errSimpleResponseCached) {
+		t.Fatalf("HTTPAPIHandler() error = %v, want %v", err, errSimpleResponseCached)
+	}
+	if response != nil {
+		t.Fatalf("HTTPAPIHandler() response = %+v, want none", response)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+)
+
+// targetTenantHeader lets SaaS provider staff (SystemAdmin, CustomerSupport) act on a specific tenant
+var targetTenantHeader = envString("TARGET_TENANT_HEADER", "X-Target-Tenant-Id")
+
+// getHeader returns the value of the named header, matched case-insensitively
+func getHeader(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// impersonationAudit is the audit record written whenever provider staff act on another tenant
+type impersonationAudit struct {
+	Event          string `json:"event"`
+	Time           string `json:"time"`
+	RequestID      string `json:"requestId"`
+	UserID         string `json:"userId"`
+	Email          string `json:"email"`
+	UserRole       string `json:"userRole"`
+	ActorTenantID  string `json:"actorTenantId"`
+	TargetTenantID string `json:"targetTenantId"`
+	MethodArn      string `json:"methodArn"`
+}
+
+func auditImpersonation(requestID, methodArn string, claims CognitoJWTClaim, targetTenantID string) {
+	record, err := json.Marshal(impersonationAudit{
+		Event:          "TenantImpersonation",
+		Time:           time.Now().UTC().Format(time.RFC3339),
+		RequestID:      requestID,
+		UserID:         claims.Subject,
+		Email:          claims.Email,
+		UserRole:       claims.UserRole,
+		ActorTenantID:  claims.TenantID,
+		TargetTenantID: targetTenantID,
+		MethodArn:      methodArn,
+	})
+	if err != nil {
+		log.Printf("Request ID: %s Error marshalling audit record: %v", requestID, err)
+		return
+	}
+
+	log.Printf("AUDIT %s", record)
+}
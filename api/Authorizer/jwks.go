@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// jwksKeys is shared by every invocation handled by a warm Lambda container
+var jwksKeys = newJWKSCache(
+	envDuration("JWKS_CACHE_TTL", time.Hour),
+	envDuration("JWKS_REFRESH_MIN_INTERVAL", time.Minute),
+	envDuration("JWKS_STALE_GRACE_PERIOD", 6*time.Hour),
+	envDuration("JWKS_FETCH_TIMEOUT", 3*time.Second),
+)
+
+// errJWKSUnavailable reports that an issuer's keys could not be fetched. It is an internal failure rather than a rejection of the token.
+var errJWKSUnavailable = errors.New("JWKS unavailable")
+
+// jwksCache caches the JSON Web Key Set of each issuer.
+//
+// A key set is considered fresh for ttl after it was fetched. A token signed with an unknown kid
+// forces a refresh (key rotation), but never more than once per minRefreshInterval per issuer.
+// If a refresh fails, the previous key set keeps being served until it is staleGrace past its ttl.
+type jwksCache struct {
+	mu                 sync.Mutex
+	entries            map[string]*jwksEntry
+	ttl                time.Duration
+	minRefreshInterval time.Duration
+	staleGrace         time.Duration
+	fetchTimeout       time.Duration
+	fetch              func(ctx context.Context, url string) (jwk.Set, error)
+	now                func() time.Time
+}
+
+type jwksEntry struct {
+	set         jwk.Set
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+func newJWKSCache(ttl, minRefreshInterval, staleGrace, fetchTimeout time.Duration) *jwksCache {
+	return &jwksCache{
+		entries:            map[string]*jwksEntry{},
+		ttl:                ttl,
+		minRefreshInterval: minRefreshInterval,
+		staleGrace:         staleGrace,
+		fetchTimeout:       fetchTimeout,
+		fetch: func(ctx context.Context, url string) (jwk.Set, error) {
+			return jwk.Fetch(ctx, url)
+		},
+		now: time.Now,
+	}
+}
+
+// LookupKey returns the key with the given kid from the issuer's key set, refreshing the set once if the kid is unknown
+func (c *jwksCache) LookupKey(ctx context.Context, issuer, keyID string) (jwk.Key, error) {
+	set, err := c.keySet(ctx, issuer, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, found := set.LookupKeyID(keyID); found {
+		return key, nil
+	}
+
+	set, err = c.keySet(ctx, issuer, true)
+	if err != nil {
+		return nil, err
+	}
+
+	key, found := set.LookupKeyID(keyID)
+	if !found {
+		return nil, JSONError{Message: "Invalid key ID"}
+	}
+
+	return key, nil
+}
+
+// keySet returns the cached key set for the issuer, fetching it when it is missing or expired, or when forceRefresh is set
+func (c *jwksCache) keySet(ctx context.Context, issuer string, forceRefresh bool) (jwk.Set, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	entry, cached := c.entries[issuer]
+
+	if cached {
+		fresh := now.Sub(entry.fetchedAt) < c.ttl
+		usable := now.Sub(entry.fetchedAt) < c.ttl+c.staleGrace
+		recentlyAttempted := now.Sub(entry.lastAttempt) < c.minRefreshInterval
+
+		if fresh && !forceRefresh {
+			return entry.set, nil
+		}
+		// Rate limit refreshes, whether forced by an unknown kid or retried after a failed fetch
+		if usable && recentlyAttempted {
+			return entry.set, nil
+		}
+		entry.lastAttempt = now
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, c.fetchTimeout)
+	defer cancel()
+
+	set, err := c.fetch(fetchCtx, issuer+"/.well-known/jwks.json")
+	if err != nil {
+		if cached && now.Sub(entry.fetchedAt) < c.ttl+c.staleGrace {
+			log.Printf("Failed to refresh JWKS for %s, serving keys fetched at %s: %v", issuer, entry.fetchedAt.Format(time.RFC3339), err)
+			return entry.set, nil
+		}
+		return nil, fmt.Errorf("%w for %s: %v", errJWKSUnavailable, issuer, err)
+	}
+
+	c.entries[issuer] = &jwksEntry{
+		set:         set,
+		fetchedAt:   now,
+		lastAttempt: now,
+	}
+
+	return set, nil
+}
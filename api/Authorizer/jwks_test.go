@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestJWKSCacheLookupKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newSet returns a key set holding a key for each kid. A step with no key set makes the fetch fail.
+	newSet := func(keyIDs ...string) jwk.Set {
+		set := jwk.NewSet()
+		for _, keyID := range keyIDs {
+			key := newTestJWK(t, &rsaKey.PublicKey, "RS256")
+			if err := key.Set(jwk.KeyIDKey, keyID); err != nil {
+				t.Fatal(err)
+			}
+			set.Add(key)
+		}
+		return set
+	}
+
+	type step struct {
+		at          time.Duration
+		keys        jwk.Set
+		lookup      string
+		wantFetches int
+		wantErr     error
+	}
+
+	invalidKeyID := JSONError{Message: "Invalid key ID"}
+
+	// The cache has a 1h TTL, a 1m refresh interval and a 6h stale grace period
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "cached within TTL",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 59 * time.Minute, keys: newSet("a"), lookup: "a", wantFetches: 1},
+			},
+		},
+		{
+			name: "refetched after TTL",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 61 * time.Minute, keys: newSet("a"), lookup: "a", wantFetches: 2},
+			},
+		},
+		{
+			name: "unknown kid forces a refresh",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 10 * time.Minute, keys: newSet("a", "b"), lookup: "b", wantFetches: 2},
+			},
+		},
+		{
+			name: "forced refresh is rate limited",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 10 * time.Minute, keys: newSet("a"), lookup: "b", wantFetches: 2, wantErr: invalidKeyID},
+				{at: 10*time.Minute + 30*time.Second, keys: newSet("a", "b"), lookup: "b", wantFetches: 2, wantErr: invalidKeyID},
+				{at: 11*time.Minute + time.Second, keys: newSet("a", "b"), lookup: "b", wantFetches: 3},
+			},
+		},
+		{
+			name: "stale keys served within grace period",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 2 * time.Hour, keys: nil, lookup: "a", wantFetches: 2},
+			},
+		},
+		{
+			name: "failed refresh retried once per interval",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 2 * time.Hour, keys: nil, lookup: "a", wantFetches: 2},
+				{at: 2*time.Hour + 30*time.Second, keys: nil, lookup: "a", wantFetches: 2},
+				{at: 2*time.Hour + 61*time.Second, keys: newSet("a"), lookup: "a", wantFetches: 3},
+			},
+		},
+		{
+			name: "fails after grace period",
+			steps: []step{
+				{at: 0, keys: newSet("a"), lookup: "a", wantFetches: 1},
+				{at: 7*time.Hour + time.Second, keys: nil, lookup: "a", wantFetches: 2, wantErr: errJWKSUnavailable},
+			},
+		},
+		{
+			name: "fails without cached keys",
+			steps: []step{
+				{at: 0, keys: nil, lookup: "a", wantFetches: 1, wantErr: errJWKSUnavailable},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var now time.Time
+			var keys jwk.Set
+			fetches := 0
+
+			cache := newJWKSCache(time.Hour, time.Minute, 6*time.Hour, time.Second)
+			cache.now = func() time.Time { return now }
+			cache.fetch = func(ctx context.Context, url string) (jwk.Set, error) {
+				fetches++
+				if url != testIssuer+"/.well-known/jwks.json" {
+					t.Fatalf("fetched %s", url)
+				}
+				if keys == nil {
+					return nil, errors.New("connection refused")
+				}
+				return keys, nil
+			}
+
+			for i, step := range tt.steps {
+				now, keys = testNow.Add(step.at), step.keys
+
+				key, err := cache.LookupKey(context.Background(), testIssuer, step.lookup)
+				if fetches != step.wantFetches {
+					t.Fatalf("step %d: fetches = %d, want %d", i, fetches, step.wantFetches)
+				}
+
+				if step.wantErr != nil {
+					if !errors.Is(err, step.wantErr) {
+						t.Fatalf("step %d: LookupKey() error = %v, want %v", i, err, step.wantErr)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("step %d: LookupKey() error = %v", i, err)
+				}
+				if key.KeyID() != step.lookup {
+					t.Fatalf("step %d: LookupKey() kid = %q, want %q", i, key.KeyID(), step.lookup)
+				}
+			}
+		})
+	}
+}
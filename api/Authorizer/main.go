@@ -33,207 +33,54 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"strings"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigateway"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/tomweston/shared-service-authorizer/utils"
 )
 
-// UserRoles enumeration
-var UserRoles = struct {
-	SYSTEM_ADMIN     string
-	CUSTOMER_SUPPORT string
-	TENANT_ADMIN     string
-	TENANT_USER      string
-}{
-	"SystemAdmin",
-	"CustomerSupport",
-	"TenantAdmin",
-	"TenantUser",
-}
-
-func isTenantAdmin(userRole string) bool {
-	return userRole == UserRoles.TENANT_ADMIN
-}
-
-func isSystemAdmin(userRole string) bool {
-	return userRole == UserRoles.SYSTEM_ADMIN
-}
+var metrics utils.AuthorizerMetrics
 
-// TODO: This function will be used when we have a SaaS provider role (e.g. SystemAdmin or CustomerSupport)
-func isSaaSProvider(userRole string) bool {
-	return userRole == UserRoles.SYSTEM_ADMIN || userRole == UserRoles.CUSTOMER_SUPPORT
+func init() {
+	metrics = utils.NewDataDogAuthorizerMetrics()
 }
 
-func isTenantUser(userRole string) bool {
-	return userRole == UserRoles.TENANT_USER
+// ServiceIdentifiers enumeration
+var ServiceIdentifiers = struct {
+	SHARED_SERVICES           string
+	DEDICATED_TENANT_SERVICES string
+}{
+	"SharedServices",
+	"DedicatedTenantServices",
 }
 
-func GetPolicyForUser(userRole, serviceIdentifier, tenantID, region, awsAccountID string) string {
-	var iamPolicy string
-
-	if isSystemAdmin(userRole) {
-		iamPolicy = GetPolicyForSystemAdmin(region, awsAccountID)
-	} else if isTenantAdmin(userRole) {
-		iamPolicy = GetPolicyForTenantAdmin(tenantID, serviceIdentifier, region, awsAccountID)
-	} else if isTenantUser(userRole) {
-		iamPolicy = GetPolicyForTenantUser(tenantID, region, awsAccountID)
+// GetPolicyForUser renders and compiles the session policy template for the role and service identifier
+func GetPolicyForUser(role RoleDefinition, serviceIdentifier string, params PolicyParameters) (string, error) {
+	if policyTemplatesErr != nil {
+		return "", policyTemplatesErr
 	}
 
-	// log.Printf("IAM Policy: %s", iamPolicy)
-	return iamPolicy
-}
-
-type APIGatewayErrorResponse struct {
-	Message   string `json:"message"`
-	RequestID string `json:"requestID"`
-}
-
-func GetPolicyForSystemAdmin(region, awsAccountID string) string {
-	policy := map[string]interface{}{
-		"Version": "2012-10-17",
-		"Statement": []map[string]interface{}{
-			{
-				"Effect": "Allow",
-				"Action": []string{
-					"dynamodb:UpdateItem",
-					"dynamodb:GetItem",
-					"dynamodb:PutItem",
-					"dynamodb:Query",
-					"dynamodb:Scan",
-					"dynamodb:DeleteItem",
-					"dynamodb:BatchWriteItem",
-					"dynamodb:BatchGetItem",
-				},
-				"Resource": []string{
-					fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/*", region, awsAccountID),
-				},
-			},
-		},
-	}
-
-	iamPolicy, _ := json.Marshal(policy)
-	return string(iamPolicy)
-}
-
-func GetPolicyForTenantAdmin(tenantID, serviceIdentifier, region, awsAccountID string) string {
-	var policy map[string]interface{}
-
-	if serviceIdentifier == "SharedServices" {
-		policy = map[string]interface{}{
-			"Version": "2012-10-17",
-			"Statement": []map[string]interface{}{
-				{
-					"Effect": "Allow",
-					"Action": []string{
-						"dynamodb:UpdateItem",
-						"dynamodb:GetItem",
-						"dynamodb:PutItem",
-						"dynamodb:Query",
-						"dynamodb:Scan",
-						"dynamodb:DeleteItem",
-						"dynamodb:BatchWriteItem",
-						"dynamodb:BatchGetItem",
-					},
-					"Resource": []string{
-						fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/SharedServices", region, awsAccountID),
-					},
-					"Condition": map[string]interface{}{
-						"ForAllValues:StringEquals": map[string]interface{}{
-							"dynamodb:LeadingKeys": []string{
-								"TENANT#" + tenantID,
-							},
-						},
-					},
-				},
-				{
-					"Effect": "Allow",
-					"Action": []string{
-						"dynamodb:UpdateItem",
-						"dynamodb:GetItem",
-						"dynamodb:PutItem",
-						"dynamodb:Query",
-						"dynamodb:Scan",
-						"dynamodb:DeleteItem",
-						"dynamodb:BatchWriteItem",
-						"dynamodb:BatchGetItem",
-					},
-					"Resource": []string{
-						fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/SharedServices", region, awsAccountID),
-					},
-				},
-			},
-		}
-	} else {
-		// This is where we would handle DedicatedTenantServices
+	template, ok := policyTemplates[role.Name+"."+serviceIdentifier]
+	if !ok {
+		return "", fmt.Errorf("%w for %s in %s", errNoPolicyTemplate, role.Name, serviceIdentifier)
 	}
 
-	iamPolicy, _ := json.Marshal(policy)
-	return string(iamPolicy)
-}
+	policy, err := renderPolicy(template, params)
+	if err != nil {
+		return "", err
+	}
 
-func GetPolicyForTenantUser(tenantID, region, awsAccountID string) string {
-	policy := map[string]interface{}{
-		"Version": "2012-10-17",
-		"Statement": []map[string]interface{}{
-			{
-				"Effect": "Allow",
-				"Action": []string{
-					"dynamodb:UpdateItem",
-					"dynamodb:GetItem",
-					"dynamodb:PutItem",
-					"dynamodb:Query",
-					"dynamodb:Scan",
-					"dynamodb:DeleteItem",
-					"dynamodb:BatchWriteItem",
-					"dynamodb:BatchGetItem",
-				},
-				"Resource": []string{
-					fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/SharedServices", region, awsAccountID),
-				},
-				"Condition": map[string]interface{}{
-					"ForAllValues:StringLike": map[string]interface{}{
-						"dynamodb:LeadingKeys": []string{
-							"TENANT#" + tenantID,
-						},
-					},
-				},
-			},
-			{
-				"Effect": "Allow",
-				"Action": []string{
-					"dynamodb:UpdateItem",
-					"dynamodb:GetItem",
-					"dynamodb:PutItem",
-					"dynamodb:Query",
-					"dynamodb:Scan",
-					"dynamodb:DeleteItem",
-					"dynamodb:BatchWriteItem",
-					"dynamodb:BatchGetItem",
-				},
-				"Resource": []string{
-					fmt.Sprintf("arn:aws:dynamodb:%s:%s:table/SharedServices", region, awsAccountID),
-				},
-				"Condition": map[string]interface{}{
-					"ForAllValues:StringLike": map[string]interface{}{
-						"dynamodb:LeadingKeys": []string{
-							"TENANT#" + tenantID,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	iamPolicy, _ := json.Marshal(policy)
-	return string(iamPolicy)
+	// log.Printf("IAM Policy: %+v", policy)
+	return compilePolicy(policy)
 }
 
 type CognitoJWTClaim struct {
@@ -247,11 +94,18 @@ type CognitoJWTClaim struct {
 	Email      string          `json:"email"`
 	Expiration jwt.NumericDate `json:"exp"`
 	IssuedAt   int64           `json:"iat"`
+	NotBefore  int64           `json:"nbf"`
 	Audience   string          `json:"aud"`
+	ClientID   string          `json:"client_id"`
+	TokenUse   string          `json:"token_use"`
+	Groups     []string        `json:"cognito:groups"`
 	jwt.Claims
 }
 
 func (c *CognitoJWTClaim) GetIssuedAt() (*jwt.NumericDate, error) {
+	if c.IssuedAt == 0 {
+		return nil, nil
+	}
 	iat := jwt.NewNumericDate(time.Unix(c.IssuedAt, 0))
 	return iat, nil
 }
@@ -270,8 +124,12 @@ func (c *CognitoJWTClaim) GetAudience() (jwt.ClaimStrings, error) {
 }
 
 func (c *CognitoJWTClaim) GetNotBefore() (*jwt.NumericDate, error) {
-	// Cognito does not set NotBefore
-	return nil, nil
+	// Cognito does not set NotBefore, but other issuers may
+	if c.NotBefore == 0 {
+		return nil, nil
+	}
+	nbf := jwt.NewNumericDate(time.Unix(c.NotBefore, 0))
+	return nbf, nil
 }
 
 func (c *CognitoJWTClaim) GetIssuer() (string, error) {
@@ -284,83 +142,64 @@ func (c *CognitoJWTClaim) GetSubject() (string, error) {
 
 type Response events.APIGatewayCustomAuthorizerResponse
 
-func assumeRole(sess *session.Session, roleArn, roleSessionName, policy string) (*sts.AssumeRoleOutput, error) {
+func assumeRole(sess *session.Session, request roleSession) (*sts.AssumeRoleOutput, error) {
 	svc := sts.New(sess)
 	input := &sts.AssumeRoleInput{
-		RoleArn:         aws.String(roleArn),
-		RoleSessionName: aws.String(roleSessionName),
-		Policy:          aws.String(policy),
+		RoleArn:         aws.String(request.RoleArn),
+		RoleSessionName: aws.String(request.SessionName),
 	}
 
-	result, err := svc.AssumeRole(input)
-	if err != nil {
-		return nil, err
+	if request.ExternalID != "" {
+		input.ExternalId = aws.String(request.ExternalID)
 	}
-	return result, nil
-}
 
-type JSONError struct {
-	Message string `json:"message"`
-}
-
-func (e JSONError) Error() string {
-	return e.Message
-}
-
-func validateJWT(authToken string) (CognitoJWTClaim, error) {
-	log.Printf("Validating JWT: %s", authToken)
-	var claims CognitoJWTClaim
-	parser := jwt.Parser{}
-	_, _, err := parser.ParseUnverified(authToken, &claims)
-	if err != nil {
-		return claims, JSONError{Message: "Failed to parse unverified token"}
+	if request.SourceIdentity != "" {
+		input.SourceIdentity = aws.String(request.SourceIdentity)
 	}
 
-	jwksURL := claims.Issuer + "/.well-known/jwks.json"
-	jwks, err := jwk.Fetch(context.Background(), jwksURL)
-	if err != nil {
-		return claims, JSONError{Message: "Failed to fetch JWKS"}
+	if request.Duration > 0 {
+		input.DurationSeconds = aws.Int64(int64(request.Duration.Seconds()))
 	}
 
-	token, err := jwt.ParseWithClaims(authToken, &claims, func(token *jwt.Token) (interface{}, error) {
-		keyID, ok := token.Header["kid"].(string)
-		if !ok {
-			return nil, JSONError{Message: "Malformed token"}
-		}
-
-		key, found := jwks.LookupKeyID(keyID)
-		if !found {
-			return nil, JSONError{Message: "Invalid key ID"}
-		}
-
-		var pubkey interface{}
-		err := key.Raw(&pubkey)
-		if err != nil {
-			return nil, JSONError{Message: "Invalid key type"}
-		}
-
-		return pubkey, nil
-	})
+	if request.Policy != "" {
+		input.Policy = aws.String(request.Policy)
+	}
 
-	if !token.Valid {
-		return claims, JSONError{Message: "Token is not valid"}
+	for _, key := range sortedKeys(request.Tags) {
+		input.Tags = append(input.Tags, &sts.Tag{
+			Key:   aws.String(key),
+			Value: aws.String(request.Tags[key]),
+		})
 	}
 
-	if time.Unix(claims.Expiration.Unix(), 0).Before(time.Now()) {
-		return claims, JSONError{Message: "Token has expired"}
+	if len(request.Tags) > 0 && len(request.TransitiveTagKeys) > 0 {
+		input.TransitiveTagKeys = aws.StringSlice(request.TransitiveTagKeys)
 	}
 
+	result, err := svc.AssumeRole(input)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == sts.ErrCodePackedPolicyTooLargeException {
+		// checkPackedSize only estimates the packed size, so STS may still reject the session
+		return nil, fmt.Errorf("%w: %v", errPolicyTooLarge, err)
+	}
 	if err != nil {
-		return claims, JSONError{Message: "Failed to parse token"}
+		return nil, err
 	}
+	return result, nil
+}
 
-	return claims, nil
+type JSONError struct {
+	Message string `json:"message"`
+}
+
+func (e JSONError) Error() string {
+	return e.Message
 }
 
-func getUsageIdentifierKey(tier string) (string, error) {
+func getUsageIdentifierKey(tier, region string) (string, error) {
 
 	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("eu-west-2"), // TODO: Get this from environment variable
+		Region: aws.String(region),
 	})
 	if err != nil {
 		log.Printf("Error creating AWS session: %v", err)
@@ -387,8 +226,21 @@ func getUsageIdentifierKey(tier string) (string, error) {
 	return "", errors.New("usage identifier key not found")
 }
 
+var (
+	cachedAWSAccountIDMu sync.Mutex
+	cachedAWSAccountID   string
+)
+
+// getAWSAccountID returns the Lambda's account ID, calling STS only until the first successful lookup
 func getAWSAccountID() (string, error) {
-	sess, err := session.NewSession()
+	cachedAWSAccountIDMu.Lock()
+	defer cachedAWSAccountIDMu.Unlock()
+
+	if cachedAWSAccountID != "" {
+		return cachedAWSAccountID, nil
+	}
+
+	sess, err := sharedSession()
 	if err != nil {
 		log.Printf("Error creating AWS session: %v", err)
 		return "", err
@@ -401,52 +253,69 @@ func getAWSAccountID() (string, error) {
 		return "", err
 	}
 
-	return *result.Account, nil
+	cachedAWSAccountID = *result.Account
+	return cachedAWSAccountID, nil
 }
 
-func Handler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) (Response, error) {
-	requestID := event.RequestContext.RequestID
-
-	log.Printf("Starting Shared Service Authorizer")
-	log.Printf("Request ID: %s Authorizer Request: %v", requestID, event)
-
-	// log.Printf("Request ID: %s Method: %s Path: %s Headers: %s", requestID, event.MethodArn, event.Path, event.Headers["Authorization"])
-
-	policy := Response{
-		PrincipalID: "user",
-		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
-			Version:   "2012-10-17",
-			Statement: []events.IAMPolicyStatement{},
-		},
-		Context: map[string]interface{}{
-			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "*",
-			"Access-Control-Allow-Headers": "*",
-			"Content-Type":                 "*/*",
-		},
-	}
-
-	// Extract case-insensitive "Authorization" header
-	var authorizationHeader string
-	for k, v := range event.Headers {
-		if strings.EqualFold(k, "Authorization") {
-			authorizationHeader = v
-			break
+// Handler authorizes REST API TOKEN and REQUEST events, answering with the Unauthorized sentinel (401) for invalid
+// credentials, an explicit Deny policy (403) for forbidden callers, and a plain error (500) for internal failures
+func Handler(ctx context.Context, event restAPIEvent) (Response, error) {
+	request := normalizeRESTAPIEvent(ctx, event)
+
+	policy, rejection, err := evaluate(ctx, request)
+	if err != nil {
+		return Response{}, fmt.Errorf("internal authorizer error: %w", err)
+	}
+	if rejection == nil {
+		return policy, nil
+	}
+
+	if rejection.Status == http.StatusUnauthorized {
+		return Response{}, errUnauthorized
+	}
+
+	return denyResponse("user", request.MethodArn, rejection.Reason), nil
+}
+
+// authorize validates the caller and vends tenant-scoped credentials. Callers that are not allowed in are
+// reported as a Rejection; any other error is an internal failure.
+func authorize(ctx context.Context, request authorizerRequest) (Response, error) {
+	requestID := request.RequestID
+
+	policy := newResponse("user")
+
+	token := request.Token
+	if token == "" {
+		var err error
+		token, err = tokens.Extract(request.Headers, request.Cookies)
+		if err != nil {
+			return policy, err
 		}
 	}
 
-	log.Printf("Extracted Authorization Header Value: %v", authorizationHeader)
+	claims, err := validateJWT(ctx, token, request.APIID)
+
+	var invalidToken JSONError
+	if errors.As(err, &invalidToken) {
+		return policy, unauthorized(invalidToken.Message)
+	}
+	if err != nil {
+		return policy, err
+	}
 
-	claims, err := validateJWT(authorizationHeader)
+	// Without valid templates no role is registered, which is a deployment fault rather than an unknown role
+	if policyTemplatesErr != nil {
+		log.Printf("Request ID: %s Error loading policy templates: %v", requestID, policyTemplatesErr)
+		return policy, policyTemplatesErr
+	}
 
+	role, err := roles.Resolve(claims.UserRole)
 	if err != nil {
-		log.Printf("Request ID: %s Rejected: %v", requestID, err)
-		response := APIGatewayErrorResponse{
-			Message:   fmt.Sprintf("Rejected: %v", err),
-			RequestID: requestID,
-		}
-		responseJSON, _ := json.Marshal(response)
-		return policy, fmt.Errorf(string(responseJSON))
+		return policy, forbidden(err.Error())
+	}
+
+	if request.CheckRoutes && !routeAllowed(role, request.MethodArn) {
+		return policy, forbidden("Route not allowed for role " + role.Name)
 	}
 
 	awsAccountID, err := getAWSAccountID()
@@ -455,56 +324,174 @@ func Handler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequest
 		return policy, err
 	}
 
-	// A map of region aliases to actual region names
-	var region string
-	switch claims.Region {
-	case "eu1":
-		region = "eu-west-2"
-	case "us1":
-		region = "us-east-1"
-	case "ap1":
-		region = "ap-southeast-1"
-	default:
-		log.Fatalf("Unexpected region: %s", claims.Region)
+	regions, err := regionRegistry()
+	if err != nil {
+		log.Printf("Request ID: %s Error loading region registry: %v", requestID, err)
+		return policy, err
 	}
 
-	// TODO: Determine serviceIdentifier from ServiceIdentifier (e.g. SharedServices, DedicatedTenantServices) by looking up the value in the tenantDetails table
+	regionDefinition, err := regions.Resolve(claims.Region)
+	if err != nil {
+		return policy, forbidden(err.Error())
+	}
+	region := regionDefinition.AWSRegion
 
-	iamPolicy := GetPolicyForUser(claims.UserRole, "SharedServices", claims.TenantID, region, awsAccountID)
+	registry, err := tenantRegistry()
+	if err != nil {
+		return policy, err
+	}
 
-	roleArn := "arn:aws:iam::" + awsAccountID + ":role/AuthorizerAccessRole"
+	// An empty key is rejected by DynamoDB, and a token without a tenant is not allowed in anyway
+	if claims.TenantID == "" {
+		return policy, forbidden("Missing tenant")
+	}
 
-	policy.PolicyDocument.Statement = append(policy.PolicyDocument.Statement, events.IAMPolicyStatement{
-		Action:   []string{"execute-api:Invoke"},
-		Effect:   "Allow",
-		Resource: []string{event.MethodArn},
-	})
+	tenant, err := registry.GetTenant(ctx, claims.TenantID)
+	if errors.Is(err, errTenantNotFound) {
+		return policy, forbidden("Unknown tenant")
+	}
+	if err != nil {
+		log.Printf("Request ID: %s Error getting tenant details: %v", requestID, err)
+		return policy, err
+	}
+
+	if !tenant.IsActive() {
+		metrics.RecordAuthorizerEvent(ctx, "tenant_blocked", "tenant:"+claims.TenantID, "tenant_status:"+tenant.Status)
+		return policy, forbidden("Tenant " + tenant.blockedReason())
+	}
+
+	// SaaS provider staff may act on another tenant, whose details then drive the policy
+	tenantID := claims.TenantID
+	targetTenantID := getHeader(request.Headers, targetTenantHeader)
+	if targetTenantID != "" && targetTenantID != claims.TenantID {
+		if !role.SaaSProvider {
+			return policy, forbidden("Tenant impersonation not allowed")
+		}
+
+		// The target tenant header is not part of the authorizer cache key
+		if isCacheSafeMode() {
+			return policy, forbidden("Tenant impersonation not available while authorizer results are cached")
+		}
+
+		tenant, err = registry.GetTenant(ctx, targetTenantID)
+		if errors.Is(err, errTenantNotFound) {
+			return policy, forbidden("Unknown target tenant")
+		}
+		if err != nil {
+			log.Printf("Request ID: %s Error getting target tenant details: %v", requestID, err)
+			return policy, err
+		}
+
+		if !tenant.IsActive() {
+			metrics.RecordAuthorizerEvent(ctx, "tenant_blocked", "tenant:"+targetTenantID, "tenant_status:"+tenant.Status)
+			return policy, forbidden("Target tenant " + tenant.blockedReason())
+		}
+
+		auditImpersonation(requestID, request.MethodArn, claims, targetTenantID)
+		tenantID = targetTenantID
+	}
+
+	serviceIdentifier := tenant.ServiceIdentifier()
+
+	target, err := resolveTargetRole(tenant, awsAccountID)
+	if err != nil {
+		log.Printf("Request ID: %s Error resolving target role: %v", requestID, err)
+		return policy, err
+	}
 
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
+	sessionRequest := roleSession{
+		RoleArn:        target.Arn,
+		ExternalID:     target.ExternalID,
+		SessionName:    roleSessionName(tenantID, requestID),
+		SourceIdentity: sourceIdentity(claims),
+		Duration:       sessionDuration(claims.Expiration.Time, time.Now()),
+	}
+
+	if useABAC(serviceIdentifier, target) {
+		sessionRequest.Tags, err = abacSessionTags(tenantID, role.Name, tenant.Tier, claims.Subject)
+		if err != nil {
+			log.Printf("Request ID: %s Error building session tags: %v", requestID, err)
+			return policy, err
+		}
+		sessionRequest.TransitiveTagKeys = abacTransitiveTagKeys
+	} else {
+		sessionRequest.Policy, err = GetPolicyForUser(role, serviceIdentifier, PolicyParameters{
+			TenantID:    tenantID,
+			TenantTable: tenant.TableName,
+			Region:      region,
+			AccountID:   target.AccountID,
+			UserID:      claims.Subject,
+		})
+		if errors.Is(err, errNoPolicyTemplate) || errors.Is(err, errNoTenantTable) {
+			return policy, forbidden(err.Error())
+		}
+		if errors.Is(err, errPolicyTooLarge) {
+			metrics.RecordAuthorizerEvent(ctx, "policy_too_large", "user_role:"+role.Name, "service_identifier:"+serviceIdentifier)
+		}
+		if err != nil {
+			log.Printf("Request ID: %s Error rendering policy: %v", requestID, err)
+			return policy, err
+		}
+	}
+
+	if err := checkPackedSize(sessionRequest); err != nil {
+		metrics.RecordAuthorizerEvent(ctx, "policy_too_large", "user_role:"+role.Name, "service_identifier:"+serviceIdentifier)
+		log.Printf("Request ID: %s Error checking session size: %v", requestID, err)
+		return policy, err
+	}
+
+	if request.WebSocket {
+		// The policy is only evaluated for $connect, so the connection itself is allowed
+		policy.PolicyDocument.Statement = append(policy.PolicyDocument.Statement, events.IAMPolicyStatement{
+			Action:   []string{"execute-api:Invoke"},
+			Effect:   "Allow",
+			Resource: []string{request.MethodArn},
+		})
+	} else {
+		routes, err := routeStatements(role, request.MethodArn)
+		if err != nil {
+			log.Printf("Request ID: %s Error rendering route permissions: %v", requestID, err)
+			return policy, err
+		}
+		policy.PolicyDocument.Statement = append(policy.PolicyDocument.Statement, routes...)
+	}
 
-	roleSessionName := fmt.Sprintf("%s-%s", claims.TenantID, requestID)
+	sess, err := sharedSession()
+	if err != nil {
+		return policy, err
+	}
 
-	assumedRole, err := assumeRole(sess, roleArn, roleSessionName, iamPolicy)
+	key := credentialKey(tenantID, role.Name, serviceIdentifier, sessionRequest)
+	creds, err := credentials.Get(key, func() (*sts.Credentials, error) {
+		assumedRole, err := assumeRole(sess, sessionRequest)
+		if err != nil {
+			return nil, err
+		}
+		return assumedRole.Credentials, nil
+	})
+	if errors.Is(err, errPolicyTooLarge) {
+		metrics.RecordAuthorizerEvent(ctx, "policy_too_large", "user_role:"+role.Name, "service_identifier:"+serviceIdentifier)
+	}
 	if err != nil {
 		log.Printf("Request ID: %s Error assuming role: %v", requestID, err)
 		return policy, err
 	}
 
 	policy.Context = map[string]interface{}{
-		"accessKeyId":     *assumedRole.Credentials.AccessKeyId,
-		"secretAccessKey": *assumedRole.Credentials.SecretAccessKey,
-		"sessionToken":    *assumedRole.Credentials.SessionToken,
-		"tenantId":        claims.TenantID,
-		"userRole":        claims.UserRole,
-		"email":           claims.Email,
-		"region":          claims.Region,
-		"awsRegion":       region,
-		"firstName":       claims.FirstName,
-		"lastName":        claims.LastName,
-		"requestId":       requestID,
-		"userId":          claims.Subject,
+		"accessKeyId":       *creds.AccessKeyId,
+		"secretAccessKey":   *creds.SecretAccessKey,
+		"sessionToken":      *creds.SessionToken,
+		"tenantId":          tenantID,
+		"userRole":          claims.UserRole,
+		"email":             claims.Email,
+		"region":            claims.Region,
+		"awsRegion":         region,
+		"firstName":         claims.FirstName,
+		"lastName":          claims.LastName,
+		"userId":            claims.Subject,
+		"tenantTier":        tenant.Tier,
+		"serviceIdentifier": serviceIdentifier,
+		"homeRegion":        tenant.HomeRegion,
 		// CORS headers
 		"Access-Control-Allow-Origin":  "*",
 		"Access-Control-Allow-Methods": "*",
@@ -512,9 +499,21 @@ func Handler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequest
 		"Content-Type":                 "*/*",
 	}
 
-	tenantTier := "Premier" // TODO: Determine tnantTier from tenantDetails table
+	if regionDefinition.DynamoDBEndpoint != "" {
+		policy.Context["dynamoDBEndpoint"] = regionDefinition.DynamoDBEndpoint
+	}
 
-	usageIdentifierKey, err := getUsageIdentifierKey(tenantTier)
+	// A cached response is returned for other requests, so it must not carry this request's ID
+	if !isCacheSafeMode() {
+		policy.Context["requestId"] = requestID
+	}
+
+	if tenantID != claims.TenantID {
+		policy.Context["actorTenantId"] = claims.TenantID
+		policy.Context["targetTenantId"] = tenantID
+	}
+
+	usageIdentifierKey, err := getUsageIdentifierKey(tenant.Tier, regionDefinition.APIGatewayRegion)
 
 	if err != nil {
 		log.Printf("Request ID: %s Error getting usage identifier key: %v", requestID, err)
@@ -530,12 +529,30 @@ func Handler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequest
 		return policy, err
 	}
 
-	log.Printf("Request ID: %s Accepted", requestID)
-
 	return policy, nil
+}
 
+// AuthorizerModes are the kinds of API the authorizer can be attached to
+var AuthorizerModes = struct {
+	REST      string
+	HTTP      string
+	WEBSOCKET string
+}{
+	"rest",
+	"http",
+	"websocket",
 }
 
+// authorizerMode selects the event format the Lambda handles (AUTHORIZER_MODE, rest, http or websocket)
+var authorizerMode = envString("AUTHORIZER_MODE", AuthorizerModes.REST)
+
 func main() {
-	lambda.Start(Handler)
+	switch authorizerMode {
+	case AuthorizerModes.HTTP:
+		lambda.Start(HTTPAPIHandler)
+	case AuthorizerModes.WEBSOCKET:
+		lambda.Start(WebSocketHandler)
+	default:
+		lambda.Start(Handler)
+	}
 }
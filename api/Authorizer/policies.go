@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Policy templates are JSON IAM policy documents named "<role>.<serviceIdentifier>.json". Resource and condition values
+// may contain the placeholders {{tenantId}}, {{tenantTable}}, {{region}}, {{accountId}} and {{userId}}, and actions
+// may reference the named action lists in actions.json as "@<name>".
+//
+// roles.json registers the roles the templates are written for. Files in POLICY_TEMPLATE_DIR (e.g. a Lambda layer
+// mounted at /opt/policies) override or add to the embedded templates, and roles in its roles.json are added to the embedded ones.
+//
+//go:embed policies/*.json
+var embeddedPolicies embed.FS
+
+const (
+	policyVersion     = "2012-10-17"
+	actionSetsFile    = "actions.json"
+	rolesFile         = "roles.json"
+	actionSetPrefix   = "@"
+	policyFileExt     = ".json"
+	policyTemplateDir = "policies"
+)
+
+var policyPlaceholder = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+var policyPlaceholderNames = map[string]bool{
+	"tenantId":    true,
+	"tenantTable": true,
+	"region":      true,
+	"accountId":   true,
+	"userId":      true,
+}
+
+// PolicyDocument is an IAM policy document
+type PolicyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []PolicyStatement `json:"Statement"`
+}
+
+// PolicyStatement is a statement of an IAM policy document. Condition values are always lists.
+type PolicyStatement struct {
+	Sid       string                         `json:"Sid,omitempty"`
+	Effect    string                         `json:"Effect"`
+	Action    []string                       `json:"Action"`
+	Resource  []string                       `json:"Resource"`
+	Condition map[string]map[string][]string `json:"Condition,omitempty"`
+}
+
+// PolicyParameters are the values substituted for the placeholders of a policy template
+type PolicyParameters struct {
+	TenantID string
+	// TenantTable is the dedicated tenant's table name from the tenant registry. Templates using {{tenantTable}}
+	// cannot be rendered without it: a "<tenantId>-*" prefix would also match the tables of a tenant whose ID
+	// extends this one, such as "acme-corp" for "acme".
+	TenantTable string
+	Region      string
+	AccountID   string
+	UserID      string
+}
+
+// errNoPolicyTemplate reports a registered role that has no template for a service identifier
+var errNoPolicyTemplate = errors.New("No policy template")
+
+// errNoTenantTable reports a template scoped to the tenant's table, rendered for a tenant whose registry record names none
+var errNoTenantTable = errors.New("No table for tenant")
+
+// policyTemplates holds the validated templates keyed by "<role>.<serviceIdentifier>", and roles the roles they are written for
+var policyTemplates, roles, policyTemplatesErr = loadPolicyTemplatesFromEnv()
+
+func loadPolicyTemplatesFromEnv() (map[string]PolicyDocument, *RoleRegistry, error) {
+	embedded, err := fs.Sub(embeddedPolicies, policyTemplateDir)
+	if err != nil {
+		return nil, NewRoleRegistry(), err
+	}
+
+	var override fs.FS
+	if dir := envString("POLICY_TEMPLATE_DIR", ""); dir != "" {
+		override = os.DirFS(dir)
+	}
+
+	templates, registry, err := loadPolicyTemplates(embedded, override)
+	if err != nil {
+		log.Printf("Invalid policy templates: %v", err)
+		// With no roles registered every request is denied
+		return nil, NewRoleRegistry(), err
+	}
+
+	return templates, registry, nil
+}
+
+// loadPolicyTemplates reads and validates the templates of base, letting files of the same name in override replace them
+func loadPolicyTemplates(base, override fs.FS) (map[string]PolicyDocument, *RoleRegistry, error) {
+	files := map[string][]byte{}
+	registry := NewRoleRegistry()
+	for _, fsys := range []fs.FS{base, override} {
+		if fsys == nil {
+			continue
+		}
+
+		names, err := fs.Glob(fsys, "*"+policyFileExt)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, name := range names {
+			data, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				return nil, nil, err
+			}
+			files[name] = data
+		}
+
+		// Roles are merged rather than replaced, so an override only needs to list the roles it adds or changes
+		if data, ok := files[rolesFile]; ok {
+			var definitions map[string]RoleDefinition
+			if err := json.Unmarshal(data, &definitions); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", rolesFile, err)
+			}
+			for name, role := range definitions {
+				for _, rule := range role.Routes {
+					if err := validateRouteRule(rule); err != nil {
+						return nil, nil, fmt.Errorf("%s: role %s: %w", rolesFile, name, err)
+					}
+				}
+				role.Name = name
+				registry.Register(role)
+			}
+			delete(files, rolesFile)
+		}
+	}
+
+	actionSets := map[string][]string{}
+	if data, ok := files[actionSetsFile]; ok {
+		if err := json.Unmarshal(data, &actionSets); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", actionSetsFile, err)
+		}
+		delete(files, actionSetsFile)
+	}
+
+	templates := map[string]PolicyDocument{}
+	for name, data := range files {
+		var template PolicyDocument
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&template); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if err := expandActionSets(&template, actionSets); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if err := validatePolicyTemplate(template); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		key := strings.TrimSuffix(path.Base(name), policyFileExt)
+		role, _, _ := strings.Cut(key, ".")
+		if _, err := registry.Resolve(role); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		templates[key] = template
+	}
+
+	for _, name := range registry.Names() {
+		if !hasPolicyTemplate(templates, name) {
+			return nil, nil, fmt.Errorf("role %s has no policy templates", name)
+		}
+	}
+
+	return templates, registry, nil
+}
+
+func hasPolicyTemplate(templates map[string]PolicyDocument, role string) bool {
+	for key := range templates {
+		if strings.HasPrefix(key, role+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandActionSets replaces "@<name>" action references with the actions of the named set
+func expandActionSets(template *PolicyDocument, actionSets map[string][]string) error {
+	for i, statement := range template.Statement {
+		var actions []string
+		for _, action := range statement.Action {
+			if !strings.HasPrefix(action, actionSetPrefix) {
+				actions = append(actions, action)
+				continue
+			}
+
+			set, ok := actionSets[strings.TrimPrefix(action, actionSetPrefix)]
+			if !ok {
+				return fmt.Errorf("unknown action set %s", action)
+			}
+			actions = append(actions, set...)
+		}
+		template.Statement[i].Action = actions
+	}
+	return nil
+}
+
+func validatePolicyTemplate(template PolicyDocument) error {
+	if template.Version != policyVersion {
+		return fmt.Errorf("unsupported policy version %q", template.Version)
+	}
+
+	if len(template.Statement) == 0 {
+		return fmt.Errorf("policy has no statements")
+	}
+
+	for i, statement := range template.Statement {
+		if statement.Effect != "Allow" && statement.Effect != "Deny" {
+			return fmt.Errorf("statement %d: invalid effect %q", i, statement.Effect)
+		}
+		if len(statement.Action) == 0 {
+			return fmt.Errorf("statement %d: no actions", i)
+		}
+		if len(statement.Resource) == 0 {
+			return fmt.Errorf("statement %d: no resources", i)
+		}
+
+		if statement.Effect == "Allow" && hasLeadingKeysCondition(statement) && allowsAction(statement, "dynamodb:Scan") {
+			return fmt.Errorf("statement %d: dynamodb:Scan cannot be scoped by dynamodb:LeadingKeys", i)
+		}
+
+		values := append([]string{}, statement.Resource...)
+		for _, condition := range statement.Condition {
+			for _, conditionValues := range condition {
+				values = append(values, conditionValues...)
+			}
+		}
+
+		for _, value := range values {
+			for _, match := range policyPlaceholder.FindAllStringSubmatch(value, -1) {
+				if !policyPlaceholderNames[match[1]] {
+					return fmt.Errorf("statement %d: unknown placeholder %s", i, match[0])
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasLeadingKeysCondition reports whether the statement restricts the partition keys it applies to. A Scan sends no
+// partition keys, and ForAllValues conditions hold for requests without values, so such a statement would let it
+// read every partition.
+func hasLeadingKeysCondition(statement PolicyStatement) bool {
+	for _, condition := range statement.Condition {
+		if _, ok := condition["dynamodb:LeadingKeys"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAction reports whether any of the statement's actions, which may contain wildcards, matches action
+func allowsAction(statement PolicyStatement, action string) bool {
+	for _, pattern := range statement.Action {
+		if routePatternMatches(pattern, action) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicyValue refuses a value that would widen the resource or condition it is substituted into. "#" separates
+// the segments of partition keys, so a tenant or user ID containing it could match another's partition.
+func checkPolicyValue(value string) error {
+	if strings.ContainsAny(value, "*?#") || strings.Contains(value, "${") {
+		return fmt.Errorf("%q", value)
+	}
+	return nil
+}
+
+// values returns the placeholder values, refusing any that could widen a resource or condition with IAM wildcards,
+// variables or partition key separators
+func (p PolicyParameters) values() (map[string]string, error) {
+	values := map[string]string{
+		"tenantId":    p.TenantID,
+		"tenantTable": p.TenantTable,
+		"region":      p.Region,
+		"accountId":   p.AccountID,
+		"userId":      p.UserID,
+	}
+
+	for name, value := range values {
+		if err := checkPolicyValue(value); err != nil {
+			return nil, fmt.Errorf("invalid value for policy placeholder %s: %w", name, err)
+		}
+	}
+
+	return values, nil
+}
+
+// renderPolicy returns a copy of the template with its placeholders replaced by the parameters
+func renderPolicy(template PolicyDocument, params PolicyParameters) (PolicyDocument, error) {
+	values, err := params.values()
+	if err != nil {
+		return PolicyDocument{}, err
+	}
+
+	missingTable := false
+	substitute := func(value string) string {
+		return policyPlaceholder.ReplaceAllStringFunc(value, func(placeholder string) string {
+			name := policyPlaceholder.FindStringSubmatch(placeholder)[1]
+			if name == "tenantTable" && values[name] == "" {
+				missingTable = true
+			}
+			return values[name]
+		})
+	}
+
+	policy := PolicyDocument{Version: template.Version}
+	for _, statement := range template.Statement {
+		rendered := PolicyStatement{
+			Sid:    statement.Sid,
+			Effect: statement.Effect,
+			Action: append([]string{}, statement.Action...),
+		}
+
+		for _, resource := range statement.Resource {
+			rendered.Resource = append(rendered.Resource, substitute(resource))
+		}
+
+		if statement.Condition != nil {
+			rendered.Condition = map[string]map[string][]string{}
+			for operator, condition := range statement.Condition {
+				rendered.Condition[operator] = map[string][]string{}
+				for key, conditionValues := range condition {
+					for _, value := range conditionValues {
+						rendered.Condition[operator][key] = append(rendered.Condition[operator][key], substitute(value))
+					}
+				}
+			}
+		}
+
+		policy.Statement = append(policy.Statement, rendered)
+	}
+
+	if missingTable {
+		return PolicyDocument{}, fmt.Errorf("%w %s", errNoTenantTable, params.TenantID)
+	}
+
+	return policy, nil
+}
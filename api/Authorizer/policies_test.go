@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testPolicyParameters = PolicyParameters{
+	TenantID:    "t1",
+	TenantTable: "t1-orders",
+	Region:      "eu-west-2",
+	AccountID:   "123456789012",
+	UserID:      "u1",
+}
+
+func renderTestPolicy(t *testing.T, role, serviceIdentifier string) PolicyDocument {
+	t.Helper()
+
+	if policyTemplatesErr != nil {
+		t.Fatalf("loading policy templates: %v", policyTemplatesErr)
+	}
+
+	template, ok := policyTemplates[role+"."+serviceIdentifier]
+	if !ok {
+		t.Fatalf("no template for %s in %s", role, serviceIdentifier)
+	}
+
+	policy, err := renderPolicy(template, testPolicyParameters)
+	if err != nil {
+		t.Fatalf("rendering %s.%s: %v", role, serviceIdentifier, err)
+	}
+	return policy
+}
+
+// policyAllows evaluates the Allow statements of a session policy for a request on a single partition key, with the
+// two condition operators the templates use
+func policyAllows(t *testing.T, policy PolicyDocument, action, resource, leadingKey string) bool {
+	t.Helper()
+
+	for _, statement := range policy.Statement {
+		if statement.Effect != "Allow" || !matchesAny(statement.Action, action) || !matchesAny(statement.Resource, resource) {
+			continue
+		}
+
+		conditionsMet := true
+		for operator, condition := range statement.Condition {
+			for key, values := range condition {
+				if key != "dynamodb:LeadingKeys" {
+					t.Fatalf("unexpected condition key %s", key)
+				}
+				switch operator {
+				case "ForAllValues:StringEquals":
+					conditionsMet = conditionsMet && contains(values, leadingKey)
+				case "ForAllValues:StringLike":
+					conditionsMet = conditionsMet && matchesAny(values, leadingKey)
+				default:
+					t.Fatalf("unexpected condition operator %s", operator)
+				}
+			}
+		}
+
+		if conditionsMet {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if routePatternMatches(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTenantUserPolicyDiffersFromTenantAdmin(t *testing.T) {
+	const (
+		sharedTable    = "arn:aws:dynamodb:eu-west-2:123456789012:table/SharedServices"
+		dedicatedTable = "arn:aws:dynamodb:eu-west-2:123456789012:table/t1-orders"
+		otherTable     = "arn:aws:dynamodb:eu-west-2:123456789012:table/t2-orders"
+		// The table of tenant t1-corp, whose ID extends t1's
+		extendingTable = "arn:aws:dynamodb:eu-west-2:123456789012:table/t1-corp-orders"
+	)
+
+	reads := []string{"dynamodb:GetItem", "dynamodb:Query", "dynamodb:BatchGetItem"}
+	writes := []string{"dynamodb:PutItem", "dynamodb:UpdateItem", "dynamodb:DeleteItem", "dynamodb:BatchWriteItem"}
+
+	tests := []struct {
+		serviceIdentifier string
+		resource          string
+		leadingKey        string
+		adminReads        bool
+		adminWrites       bool
+		userReads         bool
+		userWrites        bool
+	}{
+		// Tenant-wide items: the user may read but not write them
+		{"SharedServices", sharedTable, "TENANT#t1", true, true, true, false},
+		// The user's own items
+		{"SharedServices", sharedTable, "TENANT#t1#USER#u1", true, true, true, true},
+		// Another user's items in the same tenant
+		{"SharedServices", sharedTable, "TENANT#t1#USER#u2", true, true, false, false},
+		// Other tenants, including one whose ID extends this tenant's
+		{"SharedServices", sharedTable, "TENANT#t2", false, false, false, false},
+		{"SharedServices", sharedTable, "TENANT#t1x", false, false, false, false},
+		{"SharedServices", sharedTable, "TENANT#t1x#USER#u1", false, false, false, false},
+		{"DedicatedTenantServices", dedicatedTable, "TENANT#t1", true, true, true, false},
+		{"DedicatedTenantServices", dedicatedTable, "TENANT#t1#USER#u1", true, true, true, true},
+		{"DedicatedTenantServices", dedicatedTable, "TENANT#t1#USER#u2", true, true, true, false},
+		{"DedicatedTenantServices", otherTable, "TENANT#t1#USER#u1", false, false, false, false},
+		{"DedicatedTenantServices", extendingTable, "TENANT#t1", false, false, false, false},
+		{"DedicatedTenantServices", extendingTable, "TENANT#t1#USER#u1", false, false, false, false},
+	}
+
+	for _, tt := range tests {
+		admin := renderTestPolicy(t, "TenantAdmin", tt.serviceIdentifier)
+		user := renderTestPolicy(t, "TenantUser", tt.serviceIdentifier)
+
+		for _, check := range []struct {
+			role    string
+			policy  PolicyDocument
+			actions []string
+			want    bool
+		}{
+			{"TenantAdmin", admin, reads, tt.adminReads},
+			{"TenantAdmin", admin, writes, tt.adminWrites},
+			{"TenantUser", user, reads, tt.userReads},
+			{"TenantUser", user, writes, tt.userWrites},
+		} {
+			for _, action := range check.actions {
+				if got := policyAllows(t, check.policy, action, tt.resource, tt.leadingKey); got != check.want {
+					t.Errorf("%s %s %s on %s key %s: allowed = %v, want %v",
+						tt.serviceIdentifier, check.role, action, tt.resource, tt.leadingKey, got, check.want)
+				}
+			}
+		}
+	}
+}
+
+// A Scan carries no partition keys, so only a policy scoped to the tenant's own table may allow it
+func TestScanOnlyOnTenantTables(t *testing.T) {
+	tests := []struct {
+		role              string
+		serviceIdentifier string
+		wantScan          bool
+	}{
+		{"TenantAdmin", "SharedServices", false},
+		{"TenantUser", "SharedServices", false},
+		{"TenantAdmin", "DedicatedTenantServices", true},
+		{"TenantUser", "DedicatedTenantServices", false},
+	}
+
+	for _, tt := range tests {
+		policy := renderTestPolicy(t, tt.role, tt.serviceIdentifier)
+		if got := hasAction(policy, "dynamodb:Scan"); got != tt.wantScan {
+			t.Errorf("%s.%s allows Scan = %v, want %v", tt.role, tt.serviceIdentifier, got, tt.wantScan)
+		}
+	}
+}
+
+func TestPolicyTemplateRejectsScanScopedByLeadingKeys(t *testing.T) {
+	for _, actions := range [][]string{{"dynamodb:Scan"}, {"dynamodb:*"}, {"*"}} {
+		template := PolicyDocument{Version: policyVersion, Statement: []PolicyStatement{{
+			Effect:    "Allow",
+			Action:    actions,
+			Resource:  []string{"arn:aws:dynamodb:{{region}}:{{accountId}}:table/SharedServices"},
+			Condition: map[string]map[string][]string{"ForAllValues:StringEquals": {"dynamodb:LeadingKeys": {"TENANT#{{tenantId}}"}}},
+		}}}
+
+		if err := validatePolicyTemplate(template); err == nil {
+			t.Errorf("validatePolicyTemplate() with actions %v succeeded, want an error", actions)
+		}
+	}
+}
+
+func hasAction(policy PolicyDocument, action string) bool {
+	for _, statement := range policy.Statement {
+		if statement.Effect == "Allow" && contains(statement.Action, action) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPolicyParametersRejectPartitionSeparators(t *testing.T) {
+	for _, params := range []PolicyParameters{
+		{TenantID: "t1#USER#u2", UserID: "u1"},
+		{TenantID: "t1", UserID: "u1#x"},
+		{TenantID: "t*", UserID: "u1"},
+		{TenantID: "t1", UserID: "${aws:username}"},
+	} {
+		if _, err := params.values(); err == nil || !strings.Contains(err.Error(), "invalid value") {
+			t.Errorf("values() for %+v error = %v, want invalid value", params, err)
+		}
+	}
+}
+
+func TestDedicatedPolicyRequiresTenantTable(t *testing.T) {
+	params := testPolicyParameters
+	params.TenantTable = ""
+
+	for _, role := range roles.Names() {
+		if _, err := renderPolicy(policyTemplates[role+".DedicatedTenantServices"], params); !errors.Is(err, errNoTenantTable) {
+			t.Errorf("rendering %s.DedicatedTenantServices without a table: error = %v, want %v", role, err, errNoTenantTable)
+		}
+		if _, err := renderPolicy(policyTemplates[role+".SharedServices"], params); err != nil {
+			t.Errorf("rendering %s.SharedServices without a table: error = %v", role, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// maxSessionPolicySize is the number of characters STS accepts for an inline session policy
+const maxSessionPolicySize = 2048
+
+// STS also limits the packed (compressed) size of the session policy and session tags together, using an encoding it
+// does not publish. estimatePackedSize bounds it conservatively: compression never makes the policy larger than its
+// plaintext, and each tag is counted in full with packedTagOverhead for its framing. The estimate must stay within
+// maxPackedSessionSize, the plaintext policy limit, before AssumeRole is called; should STS still answer
+// PackedPolicyTooLarge, that is mapped to errPolicyTooLarge too.
+const (
+	maxPackedSessionSize = maxSessionPolicySize
+	packedTagOverhead    = 8
+)
+
+// errPolicyTooLarge reports a session policy STS would reject, even after compaction
+var errPolicyTooLarge = errors.New("Session policy too large")
+
+// compilePolicy compacts the rendered policy and marshals it without whitespace, failing when STS would reject it
+func compilePolicy(policy PolicyDocument) (string, error) {
+	compiled, err := json.Marshal(compactPolicy(policy))
+	if err != nil {
+		return "", err
+	}
+
+	if len(compiled) > maxSessionPolicySize {
+		return "", fmt.Errorf("%w: %d characters, limit %d", errPolicyTooLarge, len(compiled), maxSessionPolicySize)
+	}
+
+	return string(compiled), nil
+}
+
+// estimatePackedSize returns an upper bound for the packed size of the session's policy and tags
+func estimatePackedSize(session roleSession) int {
+	size := len(session.Policy)
+	for key, value := range session.Tags {
+		size += len(key) + len(value) + packedTagOverhead
+	}
+	for _, key := range session.TransitiveTagKeys {
+		size += len(key) + packedTagOverhead
+	}
+	return size
+}
+
+// checkPackedSize fails for sessions whose policy and tags might exceed the packed size STS accepts
+func checkPackedSize(session roleSession) error {
+	if size := estimatePackedSize(session); size > maxPackedSessionSize {
+		return fmt.Errorf("%w: estimated packed size %d, limit %d", errPolicyTooLarge, size, maxPackedSessionSize)
+	}
+	return nil
+}
+
+// compactPolicy returns an equivalent policy with duplicate actions, resources and statements removed, statements
+// granting the same actions on the same resources merged, and then statements granting the same actions merged.
+// Sids only document the templates, so they are dropped.
+func compactPolicy(policy PolicyDocument) PolicyDocument {
+	statements := make([]PolicyStatement, 0, len(policy.Statement))
+	for _, statement := range policy.Statement {
+		statements = append(statements, PolicyStatement{
+			Effect:    statement.Effect,
+			Action:    uniqueSorted(statement.Action),
+			Resource:  uniqueSorted(statement.Resource),
+			Condition: statement.Condition,
+		})
+	}
+
+	// Statements on the same resources under the same condition combine their actions
+	statements = mergeStatements(statements, func(s PolicyStatement) []string { return s.Resource },
+		func(merged *PolicyStatement, s PolicyStatement) {
+			merged.Action = uniqueSorted(append(merged.Action, s.Action...))
+		})
+
+	// Statements with the same actions under the same condition combine their resources
+	statements = mergeStatements(statements, func(s PolicyStatement) []string { return s.Action },
+		func(merged *PolicyStatement, s PolicyStatement) {
+			merged.Resource = uniqueSorted(append(merged.Resource, s.Resource...))
+		})
+
+	return PolicyDocument{Version: policy.Version, Statement: statements}
+}
+
+// mergeStatements merges statements sharing an effect, condition and the values returned by shared, keeping the
+// position of the first statement of each group
+func mergeStatements(statements []PolicyStatement, shared func(PolicyStatement) []string, merge func(*PolicyStatement, PolicyStatement)) []PolicyStatement {
+	var merged []PolicyStatement
+	groups := map[string]int{}
+	for _, statement := range statements {
+		key := statementKey(statement.Effect, statement.Condition, shared(statement))
+		if i, ok := groups[key]; ok {
+			merge(&merged[i], statement)
+			continue
+		}
+
+		groups[key] = len(merged)
+		merged = append(merged, statement)
+	}
+	return merged
+}
+
+func statementKey(effect string, condition map[string]map[string][]string, values []string) string {
+	// Marshalling sorts the map keys, so equal conditions produce equal keys
+	encodedCondition, _ := json.Marshal(condition)
+	encodedValues, _ := json.Marshal(values)
+	return effect + "\x00" + string(encodedCondition) + "\x00" + string(encodedValues)
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompactPolicy(t *testing.T) {
+	tenantKeys := map[string]map[string][]string{
+		"ForAllValues:StringEquals": {"dynamodb:LeadingKeys": {"TENANT#t1"}},
+	}
+	userKeys := map[string]map[string][]string{
+		"ForAllValues:StringEquals": {"dynamodb:LeadingKeys": {"TENANT#t1#USER#u1"}},
+	}
+
+	tests := []struct {
+		name  string
+		input []PolicyStatement
+		want  []PolicyStatement
+	}{
+		{
+			name: "duplicate actions and resources removed and sorted, Sid dropped",
+			input: []PolicyStatement{
+				{Sid: "Read", Effect: "Allow", Action: []string{"dynamodb:Query", "dynamodb:GetItem", "dynamodb:Query"}, Resource: []string{"b", "a", "b"}},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem", "dynamodb:Query"}, Resource: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "duplicate statements removed",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}, Condition: tenantKeys},
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}, Condition: tenantKeys},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}, Condition: tenantKeys},
+			},
+		},
+		{
+			name: "same resources merge actions",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+				{Effect: "Allow", Action: []string{"dynamodb:PutItem"}, Resource: []string{"a"}},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem", "dynamodb:PutItem"}, Resource: []string{"a"}},
+			},
+		},
+		{
+			name: "same actions merge resources",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"b"}},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "different conditions kept apart",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}, Condition: tenantKeys},
+				{Effect: "Allow", Action: []string{"dynamodb:PutItem"}, Resource: []string{"a"}, Condition: userKeys},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}, Condition: tenantKeys},
+				{Effect: "Allow", Action: []string{"dynamodb:PutItem"}, Resource: []string{"a"}, Condition: userKeys},
+			},
+		},
+		{
+			name: "different effects kept apart",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+				{Effect: "Deny", Action: []string{"dynamodb:PutItem"}, Resource: []string{"a"}},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+				{Effect: "Deny", Action: []string{"dynamodb:PutItem"}, Resource: []string{"a"}},
+			},
+		},
+		{
+			name: "actions merged before resources",
+			input: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+				{Effect: "Allow", Action: []string{"dynamodb:Query"}, Resource: []string{"a"}},
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem", "dynamodb:Query"}, Resource: []string{"b"}},
+			},
+			want: []PolicyStatement{
+				{Effect: "Allow", Action: []string{"dynamodb:GetItem", "dynamodb:Query"}, Resource: []string{"a", "b"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := compactPolicy(PolicyDocument{Version: "2012-10-17", Statement: tt.input})
+			if got.Version != "2012-10-17" {
+				t.Fatalf("compactPolicy() version = %q", got.Version)
+			}
+			if !reflect.DeepEqual(got.Statement, tt.want) {
+				t.Fatalf("compactPolicy() = %+v, want %+v", got.Statement, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilePolicy(t *testing.T) {
+	policy := PolicyDocument{Version: "2012-10-17", Statement: []PolicyStatement{
+		{Sid: "Read", Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: []string{"a"}},
+	}}
+
+	compiled, err := compilePolicy(policy)
+	if err != nil {
+		t.Fatalf("compilePolicy() error = %v", err)
+	}
+	want := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["dynamodb:GetItem"],"Resource":["a"]}]}`
+	if compiled != want {
+		t.Fatalf("compilePolicy() = %s, want %s", compiled, want)
+	}
+}
+
+func TestCompilePolicyTooLarge(t *testing.T) {
+	var resources []string
+	for i := 0; i < 100; i++ {
+		resources = append(resources, fmt.Sprintf("arn:aws:dynamodb:eu-west-2:123456789012:table/tenant-%03d", i))
+	}
+	policy := PolicyDocument{Version: "2012-10-17", Statement: []PolicyStatement{
+		{Effect: "Allow", Action: []string{"dynamodb:GetItem"}, Resource: resources},
+	}}
+
+	if _, err := compilePolicy(policy); !errors.Is(err, errPolicyTooLarge) {
+		t.Fatalf("compilePolicy() error = %v, want %v", err, errPolicyTooLarge)
+	}
+}
+
+// Every embedded template must fit, after compaction, with the longest IDs the registry is expected to hold
+func TestEmbeddedPoliciesFit(t *testing.T) {
+	if policyTemplatesErr != nil {
+		t.Fatalf("loading policy templates: %v", policyTemplatesErr)
+	}
+
+	params := PolicyParameters{
+		TenantID:    strings.Repeat("t", 64),
+		TenantTable: strings.Repeat("d", 128),
+		Region:      "ap-southeast-2",
+		AccountID:   "123456789012",
+		UserID:      strings.Repeat("u", 64),
+	}
+
+	for name, template := range policyTemplates {
+		rendered, err := renderPolicy(template, params)
+		if err != nil {
+			t.Fatalf("rendering %s: %v", name, err)
+		}
+
+		compiled, err := compilePolicy(rendered)
+		if err != nil {
+			t.Fatalf("compiling %s: %v", name, err)
+		}
+		if !json.Valid([]byte(compiled)) {
+			t.Fatalf("compiling %s: invalid JSON %s", name, compiled)
+		}
+	}
+}
+
+func TestCheckPackedSize(t *testing.T) {
+	abacTags, err := abacSessionTags(strings.Repeat("t", 64), "CustomerSupport", "Premier", strings.Repeat("u", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session roleSession
+		wantErr bool
+	}{
+		{name: "policy at the limit", session: roleSession{Policy: strings.Repeat("p", maxSessionPolicySize)}},
+		{name: "ABAC tags", session: roleSession{Tags: abacTags, TransitiveTagKeys: []string{tenantIDTag, userRoleTag}}},
+		{
+			name:    "policy and tags together over the limit",
+			session: roleSession{Policy: strings.Repeat("p", maxSessionPolicySize-100), Tags: abacTags},
+			wantErr: true,
+		},
+		{
+			name:    "tags over the limit",
+			session: roleSession{Tags: map[string]string{"a": strings.Repeat("v", 1024), "b": strings.Repeat("v", 1024)}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPackedSize(tt.session)
+			if tt.wantErr != errors.Is(err, errPolicyTooLarge) || (!tt.wantErr && err != nil) {
+				t.Fatalf("checkPackedSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
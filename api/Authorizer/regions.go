@@ -0,0 +1,185 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// regions.json maps the region aliases used in custom:region claims to their AWS resources. Deployments add or
+// replace aliases with the REGIONS environment variable or the SSM parameter named by REGIONS_SSM_PARAMETER, both
+// holding JSON in the same format, so a new region needs no code change.
+//
+//go:embed regions.json
+var embeddedRegions []byte
+
+var errUnknownRegion = errors.New("Unknown region")
+
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// RegionDefinition describes a region alias
+type RegionDefinition struct {
+	Alias string `json:"-"`
+	// AWSRegion hosts the tenant's resources and is used in session policies
+	AWSRegion string `json:"awsRegion"`
+	// Issuer is the issuer URL of the region's Cognito user pool. Tokens claiming a region without one are rejected.
+	Issuer string `json:"issuer,omitempty"`
+	// APIGatewayRegion hosts the API keys of the tier usage plans, AWSRegion when unset
+	APIGatewayRegion string `json:"apiGatewayRegion,omitempty"`
+	// DynamoDBEndpoint overrides the regional DynamoDB endpoint used by services, e.g. for a VPC endpoint
+	DynamoDBEndpoint string `json:"dynamoDBEndpoint,omitempty"`
+}
+
+// RegionRegistry resolves region aliases to region definitions
+type RegionRegistry struct {
+	regions map[string]RegionDefinition
+}
+
+func NewRegionRegistry() *RegionRegistry {
+	return &RegionRegistry{regions: map[string]RegionDefinition{}}
+}
+
+// Register adds the region, replacing any region with the same alias
+func (r *RegionRegistry) Register(region RegionDefinition) {
+	r.regions[region.Alias] = region
+}
+
+// Resolve returns the definition of the region alias, failing for unregistered aliases
+func (r *RegionRegistry) Resolve(alias string) (RegionDefinition, error) {
+	region, ok := r.regions[alias]
+	if !ok {
+		return RegionDefinition{}, fmt.Errorf("%w: %s", errUnknownRegion, alias)
+	}
+	return region, nil
+}
+
+// Issuers maps each region alias with a user pool to the pool's issuer URL
+func (r *RegionRegistry) Issuers() map[string]string {
+	issuers := map[string]string{}
+	for alias, region := range r.regions {
+		if region.Issuer != "" {
+			issuers[alias] = strings.TrimSuffix(region.Issuer, "/")
+		}
+	}
+	return issuers
+}
+
+var (
+	regionRegistryMu   sync.Mutex
+	regionRegistryInst *RegionRegistry
+)
+
+// regionRegistry returns the region registry, loading it until the first successful load so a failed SSM
+// request is retried by the next invocation
+func regionRegistry() (*RegionRegistry, error) {
+	regionRegistryMu.Lock()
+	defer regionRegistryMu.Unlock()
+
+	if regionRegistryInst != nil {
+		return regionRegistryInst, nil
+	}
+
+	sources := [][]byte{embeddedRegions}
+	if regions := envString("REGIONS", ""); regions != "" {
+		sources = append(sources, []byte(regions))
+	}
+
+	if name := envString("REGIONS_SSM_PARAMETER", ""); name != "" {
+		parameter, err := getRegionsParameter(name)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, parameter)
+	}
+
+	registry, err := loadRegionRegistry(sources, envMap("TRUSTED_ISSUERS"))
+	if err != nil {
+		log.Printf("Invalid region registry: %v", err)
+		return nil, err
+	}
+
+	regionRegistryInst = registry
+	return regionRegistryInst, nil
+}
+
+func getRegionsParameter(name string) ([]byte, error) {
+	sess, err := sharedSession()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("getting SSM parameter %s: %w", name, err)
+	}
+
+	return []byte(aws.StringValue(result.Parameter.Value)), nil
+}
+
+// loadRegionRegistry registers the regions of each source in turn, later sources replacing earlier definitions of
+// the same alias. issuers (TRUSTED_ISSUERS) then sets the user pool of already registered aliases.
+func loadRegionRegistry(sources [][]byte, issuers map[string]string) (*RegionRegistry, error) {
+	registry := NewRegionRegistry()
+	for _, source := range sources {
+		var definitions map[string]RegionDefinition
+		if err := json.Unmarshal(source, &definitions); err != nil {
+			return nil, err
+		}
+
+		for alias, region := range definitions {
+			region.Alias = alias
+			if region.APIGatewayRegion == "" {
+				region.APIGatewayRegion = region.AWSRegion
+			}
+			registry.Register(region)
+		}
+	}
+
+	for alias, issuer := range issuers {
+		region, err := registry.Resolve(alias)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_ISSUERS: %w", err)
+		}
+		region.Issuer = issuer
+		registry.Register(region)
+	}
+
+	aliases := make([]string, 0, len(registry.regions))
+	for alias := range registry.regions {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		region := registry.regions[alias]
+		if !awsRegionPattern.MatchString(region.AWSRegion) {
+			return nil, fmt.Errorf("region %s: invalid awsRegion %q", alias, region.AWSRegion)
+		}
+		if !awsRegionPattern.MatchString(region.APIGatewayRegion) {
+			return nil, fmt.Errorf("region %s: invalid apiGatewayRegion %q", alias, region.APIGatewayRegion)
+		}
+	}
+
+	return registry, nil
+}
+
+// regionIssuers returns the trusted issuers of the region registry for the token validator
+func regionIssuers() (map[string]string, error) {
+	registry, err := regionRegistry()
+	if err != nil {
+		return nil, err
+	}
+	return registry.Issuers(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// tokenEventType is the type of REST API TOKEN authorizer events
+const tokenEventType = "TOKEN"
+
+// authorizerRequest is the part of an API Gateway authorizer event the authorization core works from, whichever
+// kind of API the event came from
+type authorizerRequest struct {
+	RequestID string
+	APIID     string
+	// MethodArn is the method ARN of a REST API request or the route ARN of an HTTP API request
+	MethodArn string
+	Headers   map[string]string
+	// Cookies are the cookies HTTP APIs pass separately from the headers
+	Cookies []string
+	// Token is the token found by a source specific to the API type. When empty, the token is extracted from the
+	// headers and cookies.
+	Token string
+	// CheckRoutes applies the role's route permissions in the authorizer, for responses that cannot carry a policy
+	CheckRoutes bool
+	// WebSocket requests are $connect requests, to which route permissions do not apply
+	WebSocket bool
+}
+
+// evaluate authorizes the request, separating rejections of the caller from internal failures
+func evaluate(ctx context.Context, request authorizerRequest) (Response, *Rejection, error) {
+	log.Printf("Starting Shared Service Authorizer")
+	log.Printf("Request ID: %s Authorizer Request: %+v", request.RequestID, request)
+
+	policy, err := authorize(ctx, request)
+	if err == nil {
+		log.Printf("Request ID: %s Accepted", request.RequestID)
+		return policy, nil, nil
+	}
+
+	var rejection Rejection
+	if !errors.As(err, &rejection) {
+		log.Printf("Request ID: %s Internal error: %v", request.RequestID, err)
+		return Response{}, nil, err
+	}
+
+	if rejection.Status == http.StatusUnauthorized {
+		log.Printf("Request ID: %s Unauthorized: %s", request.RequestID, rejection.Reason)
+	} else {
+		log.Printf("Request ID: %s Forbidden: %s", request.RequestID, rejection.Reason)
+	}
+	return Response{}, &rejection, nil
+}
+
+// restAPIEvent holds the fields of both REST API authorizer event types. REQUEST events carry the request's headers
+// and context, TOKEN events only the method ARN and the value of the token's identity source in authorizationToken.
+type restAPIEvent struct {
+	events.APIGatewayCustomAuthorizerRequestTypeRequest
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+// normalizeRESTAPIEvent turns either REST API event type into an authorizerRequest
+func normalizeRESTAPIEvent(ctx context.Context, event restAPIEvent) authorizerRequest {
+	// Test invocations may omit the type, so a lone authorizationToken also marks a TOKEN event
+	isToken := event.Type == tokenEventType || (event.Type == "" && event.AuthorizationToken != "")
+	if !isToken {
+		return authorizerRequest{
+			RequestID: event.RequestContext.RequestID,
+			APIID:     event.RequestContext.APIID,
+			MethodArn: event.MethodArn,
+			Headers:   event.Headers,
+		}
+	}
+
+	// TOKEN events have no request context, so the Lambda invocation identifies the request
+	var requestID string
+	if invocation, ok := lambdacontext.FromContext(ctx); ok {
+		requestID = invocation.AwsRequestID
+	}
+
+	return authorizerRequest{
+		RequestID: requestID,
+		APIID:     apiIDFromArn(event.MethodArn),
+		MethodArn: event.MethodArn,
+		// The token's identity source is treated as the Authorization header, whichever header it was read from
+		Headers: map[string]string{"Authorization": event.AuthorizationToken},
+	}
+}
+
+// apiIDFromArn returns the API ID of a method ARN ("arn:aws:execute-api:<region>:<account>:<apiId>/<stage>/...")
+func apiIDFromArn(methodArn string) string {
+	fields := strings.SplitN(methodArn, ":", 6)
+	if len(fields) < 6 {
+		return ""
+	}
+	apiID, _, _ := strings.Cut(fields[5], "/")
+	return apiID
+}
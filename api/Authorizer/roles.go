@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	errMissingRole = errors.New("Missing user role")
+	errUnknownRole = errors.New("Unknown user role")
+)
+
+// RoleDefinition is a user role known to the authorizer. Its session policies are the templates
+// named "<role>.<serviceIdentifier>.json".
+type RoleDefinition struct {
+	Name string `json:"-"`
+	// SaaSProvider roles belong to the provider's staff, who may act on other tenants
+	SaaSProvider bool `json:"saasProvider"`
+	// Routes are the API routes the role may invoke. A role without Allow rules may invoke none.
+	Routes []RouteRule `json:"routes"`
+}
+
+// RoleRegistry resolves role names to role definitions. Roles are registered from roles.json next to the policy
+// templates, so a deployment adds a role by providing roles.json and the role's templates in POLICY_TEMPLATE_DIR.
+type RoleRegistry struct {
+	roles map[string]RoleDefinition
+}
+
+func NewRoleRegistry() *RoleRegistry {
+	return &RoleRegistry{roles: map[string]RoleDefinition{}}
+}
+
+// Register adds the role, replacing any role of the same name
+func (r *RoleRegistry) Register(role RoleDefinition) {
+	r.roles[role.Name] = role
+}
+
+// Resolve returns the definition of the named role, failing for empty and unregistered roles
+func (r *RoleRegistry) Resolve(name string) (RoleDefinition, error) {
+	if name == "" {
+		return RoleDefinition{}, errMissingRole
+	}
+
+	role, ok := r.roles[name]
+	if !ok {
+		return RoleDefinition{}, fmt.Errorf("%w: %s", errUnknownRole, name)
+	}
+
+	return role, nil
+}
+
+// Names returns the registered role names in order
+func (r *RoleRegistry) Names() []string {
+	var names []string
+	for name := range r.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
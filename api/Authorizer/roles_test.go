@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveRole(t *testing.T) {
+	if policyTemplatesErr != nil {
+		t.Fatalf("loading policy templates: %v", policyTemplatesErr)
+	}
+
+	tests := []struct {
+		name             string
+		role             string
+		wantSaaSProvider bool
+		wantErr          error
+	}{
+		{name: "SystemAdmin", role: "SystemAdmin", wantSaaSProvider: true},
+		{name: "CustomerSupport", role: "CustomerSupport", wantSaaSProvider: true},
+		{name: "TenantAdmin", role: "TenantAdmin"},
+		{name: "TenantUser", role: "TenantUser"},
+		{name: "empty", role: "", wantErr: errMissingRole},
+		{name: "unknown", role: "Auditor", wantErr: errUnknownRole},
+		{name: "case mismatch", role: "tenantuser", wantErr: errUnknownRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := roles.Resolve(tt.role)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want %v", tt.role, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.role, err)
+			}
+
+			if role.Name != tt.role {
+				t.Fatalf("Resolve(%q) name = %q", tt.role, role.Name)
+			}
+			if role.SaaSProvider != tt.wantSaaSProvider {
+				t.Fatalf("Resolve(%q) SaaSProvider = %v, want %v", tt.role, role.SaaSProvider, tt.wantSaaSProvider)
+			}
+
+			// Every role needs a policy for each service identifier
+			for _, serviceIdentifier := range []string{ServiceIdentifiers.SHARED_SERVICES, ServiceIdentifiers.DEDICATED_TENANT_SERVICES} {
+				if _, err := GetPolicyForUser(role, serviceIdentifier, testPolicyParameters); err != nil {
+					t.Fatalf("GetPolicyForUser(%s, %s) error = %v", tt.role, serviceIdentifier, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisteredRoles(t *testing.T) {
+	want := []string{"CustomerSupport", "SystemAdmin", "TenantAdmin", "TenantUser"}
+
+	got := roles.Names()
+	if len(got) != len(want) {
+		t.Fatalf("Names() = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Names() = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// RouteRule allows or denies a role the API routes matching an HTTP method and resource path. Both may use the
+// execute-api wildcards "*" and "?", e.g. {"effect": "Deny", "method": "DELETE", "resource": "/v1/*"}.
+type RouteRule struct {
+	Effect   string `json:"effect"`
+	Method   string `json:"method"`
+	Resource string `json:"resource"`
+}
+
+var routeMethods = map[string]bool{
+	"*":       true,
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"OPTIONS": true,
+}
+
+func validateRouteRule(rule RouteRule) error {
+	if rule.Effect != "Allow" && rule.Effect != "Deny" {
+		return fmt.Errorf("invalid route effect %q", rule.Effect)
+	}
+	if !routeMethods[rule.Method] {
+		return fmt.Errorf("invalid route method %q", rule.Method)
+	}
+	if !strings.HasPrefix(rule.Resource, "/") {
+		return fmt.Errorf("route resource %q does not start with /", rule.Resource)
+	}
+	return nil
+}
+
+// stageArn returns the ARN of the API stage a method ARN
+// ("arn:aws:execute-api:<region>:<account>:<apiId>/<stage>/<method>/<resource>") belongs to
+func stageArn(methodArn string) (string, error) {
+	parts := strings.SplitN(methodArn, "/", 3)
+	if len(parts) < 3 || !strings.HasPrefix(parts[0], "arn:") || parts[1] == "" {
+		return "", fmt.Errorf("invalid method ARN %q", methodArn)
+	}
+	return parts[0] + "/" + parts[1], nil
+}
+
+// routeStatements renders the role's route rules into execute-api:Invoke statements covering every route of the
+// stage, so the decision holds for any request the same caller makes to the stage. Deny rules override Allow rules.
+func routeStatements(role RoleDefinition, methodArn string) ([]events.IAMPolicyStatement, error) {
+	stage, err := stageArn(methodArn)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := map[string][]string{}
+	for _, rule := range role.Routes {
+		resources[rule.Effect] = append(resources[rule.Effect], stage+"/"+rule.Method+rule.Resource)
+	}
+
+	var statements []events.IAMPolicyStatement
+	for _, effect := range []string{"Allow", "Deny"} {
+		if len(resources[effect]) == 0 {
+			continue
+		}
+		statements = append(statements, events.IAMPolicyStatement{
+			Action:   []string{"execute-api:Invoke"},
+			Effect:   effect,
+			Resource: resources[effect],
+		})
+	}
+
+	return statements, nil
+}
+
+// routeAllowed applies the role's route rules to the request a method ARN describes, as API Gateway would apply the
+// statements from routeStatements: a matching Deny rule wins, and otherwise a matching Allow rule is required
+func routeAllowed(role RoleDefinition, methodArn string) bool {
+	parts := strings.SplitN(methodArn, "/", 4)
+	if len(parts) < 3 {
+		return false
+	}
+
+	method, resource := parts[2], "/"
+	if len(parts) == 4 {
+		resource += parts[3]
+	}
+
+	allowed := false
+	for _, rule := range role.Routes {
+		if !routePatternMatches(rule.Method, method) || !routePatternMatches(rule.Resource, resource) {
+			continue
+		}
+		if rule.Effect == "Deny" {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// routePatternMatches matches a value against an execute-api pattern, where "*" matches any characters and "?" any one
+func routePatternMatches(pattern, value string) bool {
+	expression := strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(pattern))
+	matched, _ := regexp.MatchString("^"+expression+"$", value)
+	return matched
+}
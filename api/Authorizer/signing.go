@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"log"
+	"strings"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+// signingKeyTypes maps each supported asymmetric signing algorithm to the JWK key type it requires.
+// "none" and the HMAC algorithms are deliberately absent: a public JWKS can never be used as an HMAC secret.
+var signingKeyTypes = map[string]jwa.KeyType{
+	"RS256": jwa.RSA,
+	"RS384": jwa.RSA,
+	"RS512": jwa.RSA,
+	"PS256": jwa.RSA,
+	"PS384": jwa.RSA,
+	"PS512": jwa.RSA,
+	"ES256": jwa.EC,
+	"ES384": jwa.EC,
+	"ES512": jwa.EC,
+}
+
+// signingAlgorithmsFromEnv reads ALLOWED_SIGNING_ALGORITHMS (RS256 when unset), dropping anything
+// that is not a supported asymmetric algorithm
+func signingAlgorithmsFromEnv() []string {
+	configured := envList("ALLOWED_SIGNING_ALGORITHMS")
+	if len(configured) == 0 {
+		configured = []string{"RS256"}
+	}
+
+	var algorithms []string
+	for _, alg := range configured {
+		alg = strings.ToUpper(alg)
+		if _, ok := signingKeyTypes[alg]; !ok {
+			log.Printf("Ignoring unsupported signing algorithm %q in ALLOWED_SIGNING_ALGORITHMS", alg)
+			continue
+		}
+		algorithms = append(algorithms, alg)
+	}
+
+	return algorithms
+}
+
+// checkSigningAlgorithm rejects a token header alg that is not in the allowlist
+func checkSigningAlgorithm(alg string, allowed []string) error {
+	for _, candidate := range allowed {
+		if alg == candidate {
+			return nil
+		}
+	}
+	return JSONError{Message: "Signing algorithm not allowed"}
+}
+
+// verificationKey returns the raw public key of the JWK after checking that its kty, and its alg when
+// the JWKS publishes one, are consistent with the alg in the token header
+func verificationKey(key jwk.Key, alg string) (interface{}, error) {
+	keyType, ok := signingKeyTypes[alg]
+	if !ok || key.KeyType() != keyType {
+		return nil, JSONError{Message: "Key type does not match signing algorithm"}
+	}
+
+	if keyAlg := key.Algorithm(); keyAlg != "" && keyAlg != alg {
+		return nil, JSONError{Message: "Key algorithm does not match signing algorithm"}
+	}
+
+	var pubkey interface{}
+	if err := key.Raw(&pubkey); err != nil {
+		return nil, JSONError{Message: "Invalid key type"}
+	}
+
+	switch pubkey.(type) {
+	case *rsa.PublicKey:
+		if keyType != jwa.RSA {
+			return nil, JSONError{Message: "Invalid key type"}
+		}
+	case *ecdsa.PublicKey:
+		if keyType != jwa.EC {
+			return nil, JSONError{Message: "Invalid key type"}
+		}
+	default:
+		// Private or symmetric keys have no place in a JWKS used for verification
+		return nil, JSONError{Message: "Invalid key type"}
+	}
+
+	return pubkey, nil
+}
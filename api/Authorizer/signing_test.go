@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func newTestJWK(t *testing.T, raw interface{}, alg string) jwk.Key {
+	t.Helper()
+
+	key, err := jwk.New(raw)
+	if err != nil {
+		t.Fatalf("creating JWK: %v", err)
+	}
+	if alg != "" {
+		if err := key.Set(jwk.AlgorithmKey, alg); err != nil {
+			t.Fatalf("setting JWK alg: %v", err)
+		}
+	}
+	return key
+}
+
+func TestCheckSigningAlgorithm(t *testing.T) {
+	allowed := []string{"RS256", "ES256"}
+
+	tests := []struct {
+		name    string
+		alg     string
+		wantErr bool
+	}{
+		{name: "allowed RSA algorithm", alg: "RS256"},
+		{name: "allowed EC algorithm", alg: "ES256"},
+		{name: "none", alg: "none", wantErr: true},
+		{name: "HMAC", alg: "HS256", wantErr: true},
+		{name: "supported but not allowed", alg: "RS512", wantErr: true},
+		{name: "empty", alg: "", wantErr: true},
+		{name: "case mismatch", alg: "rs256", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSigningAlgorithm(tt.alg, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkSigningAlgorithm(%q) error = %v, wantErr %v", tt.alg, err, tt.wantErr)
+			}
+
+			var jsonErr JSONError
+			if err != nil && !errors.As(err, &jsonErr) {
+				t.Fatalf("checkSigningAlgorithm(%q) error = %T, want JSONError", tt.alg, err)
+			}
+		})
+	}
+}
+
+func TestSigningAlgorithmsFromEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		want       []string
+	}{
+		{name: "unset", configured: "", want: []string{"RS256"}},
+		{name: "lower case", configured: "rs256,es384", want: []string{"RS256", "ES384"}},
+		{name: "none and HMAC dropped", configured: "none,HS256,RS512", want: []string{"RS512"}},
+		{name: "nothing supported", configured: "HS256", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_SIGNING_ALGORITHMS", tt.configured)
+
+			got := signingAlgorithmsFromEnv()
+			if len(got) != len(tt.want) {
+				t.Fatalf("signingAlgorithmsFromEnv() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("signingAlgorithmsFromEnv() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestVerificationKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     jwk.Key
+		alg     string
+		wantErr string
+	}{
+		{name: "RSA key for RS256", key: newTestJWK(t, &rsaKey.PublicKey, "RS256"), alg: "RS256"},
+		{name: "RSA key without alg for PS256", key: newTestJWK(t, &rsaKey.PublicKey, ""), alg: "PS256"},
+		{name: "EC key for ES256", key: newTestJWK(t, &ecKey.PublicKey, "ES256"), alg: "ES256"},
+		{name: "none", key: newTestJWK(t, &rsaKey.PublicKey, ""), alg: "none", wantErr: "Key type does not match signing algorithm"},
+		{name: "HMAC with symmetric key", key: newTestJWK(t, []byte("secret"), ""), alg: "HS256", wantErr: "Key type does not match signing algorithm"},
+		{name: "kty mismatch RSA key for ES256", key: newTestJWK(t, &rsaKey.PublicKey, ""), alg: "ES256", wantErr: "Key type does not match signing algorithm"},
+		{name: "kty mismatch EC key for RS256", key: newTestJWK(t, &ecKey.PublicKey, ""), alg: "RS256", wantErr: "Key type does not match signing algorithm"},
+		{name: "JWK alg mismatch", key: newTestJWK(t, &rsaKey.PublicKey, "RS512"), alg: "RS256", wantErr: "Key algorithm does not match signing algorithm"},
+		{name: "RSA private key", key: newTestJWK(t, rsaKey, "RS256"), alg: "RS256", wantErr: "Invalid key type"},
+		{name: "EC private key", key: newTestJWK(t, ecKey, "ES256"), alg: "ES256", wantErr: "Invalid key type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pubkey, err := verificationKey(tt.key, tt.alg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verificationKey() error = %v", err)
+				}
+				if pubkey == nil {
+					t.Fatal("verificationKey() returned no key")
+				}
+				return
+			}
+
+			var jsonErr JSONError
+			if !errors.As(err, &jsonErr) || jsonErr.Message != tt.wantErr {
+				t.Fatalf("verificationKey() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// targetRole is the IAM role the authorizer assumes to vend a tenant's credentials
+type targetRole struct {
+	Arn string
+	// ExternalID is required by roles in tenant-owned AWS accounts
+	ExternalID string
+	// AccountID is the account the role, and so the tenant's resources, live in
+	AccountID string
+	// ABAC is set for the default role, the only one carrying the AuthorizerAccessRoleAbacPolicy
+	ABAC bool
+}
+
+var roleArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(\d{12}):role/.+$`)
+
+var (
+	// tierRoleArns maps a tenant tier to a role ARN (TIER_ROLE_ARNS, e.g. "Premier=arn:aws:iam::111122223333:role/PremierAccessRole")
+	tierRoleArns = envMap("TIER_ROLE_ARNS")
+	// deploymentModelRoleArns maps pooled or dedicated to a role ARN (DEPLOYMENT_MODEL_ROLE_ARNS)
+	deploymentModelRoleArns = envMap("DEPLOYMENT_MODEL_ROLE_ARNS")
+	// defaultRoleName is assumed in the authorizer's own account when nothing more specific is configured
+	defaultRoleName = envString("DEFAULT_ACCESS_ROLE_NAME", "AuthorizerAccessRole")
+	// tenantAccountRoleName is assumed in a tenant's own account when the registry names the account but not the role
+	tenantAccountRoleName = envString("TENANT_ACCOUNT_ROLE_NAME", "AuthorizerAccessRole")
+)
+
+// resolveTargetRole picks the role for the tenant, from most to least specific: the tenant's own role ARN from the
+// registry, a role in the tenant's own account, the role for the tenant's tier, the role for its deployment model,
+// and finally the default role in the authorizer's account
+func resolveTargetRole(tenant *TenantDetails, awsAccountID string) (targetRole, error) {
+	defaultArn := fmt.Sprintf("arn:aws:iam::%s:role/%s", awsAccountID, defaultRoleName)
+
+	var arn string
+	switch {
+	case tenant.RoleArn != "":
+		arn = tenant.RoleArn
+	case tenant.AccountID != "":
+		arn = fmt.Sprintf("arn:aws:iam::%s:role/%s", tenant.AccountID, tenantAccountRoleName)
+	case tierRoleArns[tenant.Tier] != "":
+		arn = tierRoleArns[tenant.Tier]
+	case deploymentModelRoleArns[tenant.DeploymentModel] != "":
+		arn = deploymentModelRoleArns[tenant.DeploymentModel]
+	default:
+		arn = defaultArn
+	}
+
+	match := roleArnPattern.FindStringSubmatch(arn)
+	if match == nil {
+		return targetRole{}, fmt.Errorf("invalid role ARN %q for tenant %s", arn, tenant.TenantID)
+	}
+
+	if match[1] != awsAccountID && tenant.ExternalID == "" {
+		return targetRole{}, fmt.Errorf("cross-account role %s for tenant %s has no external ID", arn, tenant.TenantID)
+	}
+
+	return targetRole{
+		Arn:        arn,
+		ExternalID: tenant.ExternalID,
+		AccountID:  match[1],
+		ABAC:       arn == defaultArn,
+	}, nil
+}
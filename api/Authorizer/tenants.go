@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DeploymentModels enumeration
+var DeploymentModels = struct {
+	POOLED    string
+	DEDICATED string
+}{
+	"pooled",
+	"dedicated",
+}
+
+// TenantStatuses enumeration
+var TenantStatuses = struct {
+	ACTIVE    string
+	PENDING   string
+	SUSPENDED string
+	DELETED   string
+}{
+	"active",
+	"pending",
+	"suspended",
+	"deleted",
+}
+
+// TenantDetails is a tenant's record in the tenant registry
+type TenantDetails struct {
+	TenantID        string `dynamodbav:"tenantId"`
+	Tier            string `dynamodbav:"tier"`
+	DeploymentModel string `dynamodbav:"deploymentModel"`
+	HomeRegion      string `dynamodbav:"homeRegion"`
+	Status          string `dynamodbav:"status"`
+	// TableName is the tenant's own table in the dedicated deployment model
+	TableName string `dynamodbav:"tableName"`
+	// RoleArn overrides the role assumed for the tenant, e.g. a role in the tenant's own AWS account
+	RoleArn string `dynamodbav:"roleArn"`
+	// AccountID is the tenant's own AWS account, for tenants not deployed in the provider's account
+	AccountID string `dynamodbav:"accountId"`
+	// ExternalID is passed to AssumeRole for roles in the tenant's own account
+	ExternalID string `dynamodbav:"externalId"`
+}
+
+// ServiceIdentifier returns the identifier used to select the tenant's policies
+func (t *TenantDetails) ServiceIdentifier() string {
+	if t.DeploymentModel == DeploymentModels.DEDICATED {
+		return ServiceIdentifiers.DEDICATED_TENANT_SERVICES
+	}
+	return ServiceIdentifiers.SHARED_SERVICES
+}
+
+// IsActive reports whether the tenant may use the platform. Only the active status is; a record with a missing or
+// unrecognised status is treated like a suspended one.
+func (t *TenantDetails) IsActive() bool {
+	return t.Status == TenantStatuses.ACTIVE
+}
+
+// blockedReason describes why an inactive tenant is denied, completing a sentence such as "Tenant ..."
+func (t *TenantDetails) blockedReason() string {
+	switch t.Status {
+	case "":
+		return "has no status"
+	case TenantStatuses.PENDING, TenantStatuses.SUSPENDED, TenantStatuses.DELETED:
+		return "is " + t.Status
+	}
+	return fmt.Sprintf("has unknown status %q", t.Status)
+}
+
+// TenantRegistry looks up tenant details by tenant ID
+type TenantRegistry interface {
+	GetTenant(ctx context.Context, tenantID string) (*TenantDetails, error)
+}
+
+var errTenantNotFound = errors.New("tenant not found")
+
+var (
+	tenantRegistryOnce sync.Once
+	tenantRegistryInst TenantRegistry
+	tenantRegistryErr  error
+)
+
+// tenantRegistry returns the cached DynamoDB tenant registry shared by warm invocations.
+// TENANT_DETAILS_TABLE names the table (TenantDetails when unset) and TENANT_CACHE_TTL how long lookups are reused.
+func tenantRegistry() (TenantRegistry, error) {
+	tenantRegistryOnce.Do(func() {
+		sess, err := sharedSession()
+		if err != nil {
+			tenantRegistryErr = err
+			return
+		}
+
+		tenantRegistryInst = newCachedTenantRegistry(
+			&dynamoDBTenantRegistry{
+				svc:       dynamodb.New(sess),
+				tableName: envString("TENANT_DETAILS_TABLE", "TenantDetails"),
+			},
+			envDuration("TENANT_CACHE_TTL", 5*time.Minute),
+		)
+	})
+	return tenantRegistryInst, tenantRegistryErr
+}
+
+// dynamoDBTenantRegistry reads tenant details from a DynamoDB table keyed on tenantId
+type dynamoDBTenantRegistry struct {
+	svc       dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+func (r *dynamoDBTenantRegistry) GetTenant(ctx context.Context, tenantID string) (*TenantDetails, error) {
+	result, err := r.svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(r.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantID),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Item) == 0 {
+		return nil, errTenantNotFound
+	}
+
+	var tenant TenantDetails
+	err = dynamodbattribute.UnmarshalMap(result.Item, &tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tenant, nil
+}
+
+// cachedTenantRegistry keeps tenant details in memory across warm invocations for ttl
+type cachedTenantRegistry struct {
+	registry TenantRegistry
+	ttl      time.Duration
+	mu       sync.Mutex
+	entries  map[string]cachedTenant
+	now      func() time.Time
+}
+
+type cachedTenant struct {
+	tenant    *TenantDetails
+	fetchedAt time.Time
+}
+
+func newCachedTenantRegistry(registry TenantRegistry, ttl time.Duration) *cachedTenantRegistry {
+	return &cachedTenantRegistry{
+		registry: registry,
+		ttl:      ttl,
+		entries:  map[string]cachedTenant{},
+		now:      time.Now,
+	}
+}
+
+func (r *cachedTenantRegistry) GetTenant(ctx context.Context, tenantID string) (*TenantDetails, error) {
+	r.mu.Lock()
+	entry, ok := r.entries[tenantID]
+	r.mu.Unlock()
+
+	if ok && r.now().Sub(entry.fetchedAt) < r.ttl {
+		return entry.tenant, nil
+	}
+
+	tenant, err := r.registry.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.entries[tenantID] = cachedTenant{tenant: tenant, fetchedAt: r.now()}
+	r.mu.Unlock()
+
+	return tenant, nil
+}
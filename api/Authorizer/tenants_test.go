@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestTenantStatus(t *testing.T) {
+	tests := []struct {
+		status     string
+		wantActive bool
+		wantReason string
+	}{
+		{status: "active", wantActive: true},
+		{status: "pending", wantReason: "is pending"},
+		{status: "suspended", wantReason: "is suspended"},
+		{status: "deleted", wantReason: "is deleted"},
+		{status: "", wantReason: "has no status"},
+		{status: "Active", wantReason: `has unknown status "Active"`},
+		{status: "archived", wantReason: `has unknown status "archived"`},
+	}
+
+	for _, tt := range tests {
+		tenant := TenantDetails{TenantID: "t1", Status: tt.status}
+
+		if got := tenant.IsActive(); got != tt.wantActive {
+			t.Errorf("IsActive() for status %q = %v, want %v", tt.status, got, tt.wantActive)
+		}
+		if tt.wantActive {
+			continue
+		}
+		if got := tenant.blockedReason(); got != tt.wantReason {
+			t.Errorf("blockedReason() for status %q = %q, want %q", tt.status, got, tt.wantReason)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// rawScheme accepts an Authorization header holding the bare token, without a scheme
+const rawScheme = "raw"
+
+// tokenExtractor finds the caller's token in the Authorization header, a custom header or a cookie, in that order
+type tokenExtractor struct {
+	// schemes are the accepted Authorization header schemes, matched case-insensitively, and rawScheme
+	schemes []string
+	header  string
+	cookie  string
+}
+
+// tokens is configured by TOKEN_SCHEMES (default "Bearer,raw"), TOKEN_HEADER and TOKEN_COOKIE_NAME
+var tokens = tokenExtractor{
+	schemes: envListOrDefault("TOKEN_SCHEMES", "Bearer", rawScheme),
+	header:  envString("TOKEN_HEADER", ""),
+	cookie:  envString("TOKEN_COOKIE_NAME", ""),
+}
+
+// Extract returns the token from the request headers or cookies. A present but unusable Authorization header is
+// rejected rather than skipped, so the caller learns why their token was not accepted.
+func (e tokenExtractor) Extract(headers map[string]string, cookies []string) (string, error) {
+	if authorization := strings.TrimSpace(getHeader(headers, "Authorization")); authorization != "" {
+		return e.fromAuthorization(authorization)
+	}
+
+	if e.header != "" {
+		if token := strings.TrimSpace(getHeader(headers, e.header)); token != "" {
+			return token, nil
+		}
+	}
+
+	if e.cookie != "" {
+		if token := cookieValue(headers, cookies, e.cookie); token != "" {
+			return token, nil
+		}
+	}
+
+	return "", unauthorized("Missing authorization token")
+}
+
+func (e tokenExtractor) fromAuthorization(authorization string) (string, error) {
+	scheme, token, hasScheme := strings.Cut(authorization, " ")
+	if !hasScheme {
+		if e.accepts(authorization) && !strings.EqualFold(authorization, rawScheme) {
+			return "", unauthorized("Malformed Authorization header: no token after " + authorization)
+		}
+		if e.accepts(rawScheme) {
+			return authorization, nil
+		}
+		return "", unauthorized("Malformed Authorization header: expected \"<scheme> <token>\"")
+	}
+
+	if !e.accepts(scheme) {
+		return "", unauthorized("Unsupported authorization scheme: " + scheme)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", unauthorized("Malformed Authorization header: expected a single token after " + scheme)
+	}
+
+	return token, nil
+}
+
+func (e tokenExtractor) accepts(scheme string) bool {
+	for _, accepted := range e.schemes {
+		if strings.EqualFold(accepted, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// cookieValue returns the named cookie from the Cookie header of REST API requests or the cookies HTTP APIs pass separately
+func cookieValue(headers map[string]string, cookies []string, name string) string {
+	values := append([]string{getHeader(headers, "Cookie")}, cookies...)
+	request := http.Request{Header: http.Header{"Cookie": {strings.Join(values, "; ")}}}
+
+	cookie, err := request.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenValidator holds the trust configuration used to validate Cognito JWTs
+type tokenValidator struct {
+	// issuers maps a region alias (e.g. eu1) to the issuer URL of its Cognito user pool
+	issuers func() (map[string]string, error)
+	// clientIDs is the set of Cognito app clients whose tokens are accepted
+	clientIDs map[string]bool
+	// tokenUses is the set of token_use values ("id", "access") accepted by default
+	tokenUses map[string]bool
+	// apiTokenUses overrides tokenUses for individual API Gateway API IDs
+	apiTokenUses map[string]map[string]bool
+	// algorithms is the allowlist of JWS signing algorithms
+	algorithms []string
+	// leeway is the clock skew tolerated when checking exp, nbf and iat
+	leeway time.Duration
+	// maxTokenAge rejects tokens issued longer ago than this, regardless of exp. Zero disables the check.
+	maxTokenAge time.Duration
+	now         func() time.Time
+	keys        *jwksCache
+}
+
+// Cognito token_use values
+const (
+	tokenUseID     = "id"
+	tokenUseAccess = "access"
+)
+
+// Access tokens carry no custom:* attributes, so tenant, role and region are resolved from cognito:groups entries
+// using these prefixes, e.g. "tenant:abc" or "role:TenantAdmin". OAuth scopes are never used, as any user of an app
+// client may request any scope the client allows.
+const (
+	tenantAttributePrefix = "tenant:"
+	roleAttributePrefix   = "role:"
+	regionAttributePrefix = "region:"
+)
+
+var validator = newTokenValidatorFromEnv()
+
+// newTokenValidatorFromEnv reads ALLOWED_CLIENT_IDS and takes the trusted issuers from the region registry, where
+// TRUSTED_ISSUERS (e.g. "eu1=https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_abc") sets them per region alias.
+// With no issuers or client IDs every token is rejected.
+//
+// ACCEPTED_TOKEN_USES lists the token types accepted by default ("id" when unset), and API_TOKEN_USES
+// overrides it per API ID using "|" between token types, e.g. "a1b2c3=access,d4e5f6=id|access".
+//
+// CLOCK_SKEW_LEEWAY (30s when unset) and MAX_TOKEN_AGE (disabled when unset) bound the token lifetime.
+func newTokenValidatorFromEnv() *tokenValidator {
+	clientIDs := map[string]bool{}
+	for _, clientID := range envList("ALLOWED_CLIENT_IDS") {
+		clientIDs[clientID] = true
+	}
+
+	tokenUses := map[string]bool{}
+	for _, tokenUse := range envList("ACCEPTED_TOKEN_USES") {
+		tokenUses[tokenUse] = true
+	}
+	if len(tokenUses) == 0 {
+		tokenUses[tokenUseID] = true
+	}
+
+	apiTokenUses := map[string]map[string]bool{}
+	for apiID, uses := range envMap("API_TOKEN_USES") {
+		apiTokenUses[apiID] = map[string]bool{}
+		for _, tokenUse := range strings.Split(uses, "|") {
+			apiTokenUses[apiID][strings.TrimSpace(tokenUse)] = true
+		}
+	}
+
+	return &tokenValidator{
+		issuers:      regionIssuers,
+		clientIDs:    clientIDs,
+		tokenUses:    tokenUses,
+		apiTokenUses: apiTokenUses,
+		algorithms:   signingAlgorithmsFromEnv(),
+		leeway:       envDuration("CLOCK_SKEW_LEEWAY", 30*time.Second),
+		maxTokenAge:  envDuration("MAX_TOKEN_AGE", 0),
+		now:          time.Now,
+		keys:         jwksKeys,
+	}
+}
+
+func validateJWT(ctx context.Context, authToken, apiID string) (CognitoJWTClaim, error) {
+	return validator.Validate(ctx, authToken, apiID)
+}
+
+// Validate verifies the token signature against the JWKS of a trusted issuer and checks its claims
+// are acceptable for the API identified by apiID
+func (v *tokenValidator) Validate(ctx context.Context, authToken, apiID string) (CognitoJWTClaim, error) {
+	log.Printf("Validating JWT: %s", authToken)
+	var claims CognitoJWTClaim
+	parser := jwt.Parser{}
+	unverified, _, err := parser.ParseUnverified(authToken, &claims)
+	if err != nil {
+		return claims, JSONError{Message: "Failed to parse unverified token"}
+	}
+
+	if err := checkSigningAlgorithm(unverified.Method.Alg(), v.algorithms); err != nil {
+		return claims, err
+	}
+
+	issuers, err := v.issuers()
+	if err != nil {
+		return claims, err
+	}
+
+	// The issuer decides which JWKS is fetched, so it must be trusted before any network call is made
+	if err := checkIssuer(claims.Issuer, issuers); err != nil {
+		return claims, err
+	}
+
+	if err := v.checkClient(claims); err != nil {
+		return claims, err
+	}
+
+	if err := v.checkTokenUse(claims, apiID); err != nil {
+		return claims, err
+	}
+
+	token, err := jwt.ParseWithClaims(authToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		keyID, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, JSONError{Message: "Malformed token"}
+		}
+
+		key, err := v.keys.LookupKey(ctx, claims.Issuer, keyID)
+		if err != nil {
+			return nil, err
+		}
+
+		return verificationKey(key, token.Method.Alg())
+	},
+		jwt.WithValidMethods(v.algorithms),
+		jwt.WithLeeway(v.leeway),
+		jwt.WithTimeFunc(v.now),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	if err != nil {
+		var rejection JSONError
+		switch {
+		case errors.As(err, &rejection):
+			return claims, rejection
+		case errors.Is(err, errJWKSUnavailable):
+			return claims, err
+		case errors.Is(err, jwt.ErrTokenExpired):
+			return claims, JSONError{Message: "Token has expired"}
+		case errors.Is(err, jwt.ErrTokenNotValidYet):
+			return claims, JSONError{Message: "Token is not valid yet"}
+		case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+			return claims, JSONError{Message: "Token is issued in the future"}
+		}
+		return claims, JSONError{Message: "Failed to parse token"}
+	}
+
+	if !token.Valid {
+		return claims, JSONError{Message: "Token is not valid"}
+	}
+
+	if err := v.checkTokenAge(claims); err != nil {
+		return claims, err
+	}
+
+	if claims.TokenUse == tokenUseAccess {
+		v.resolveAccessTokenAttributes(&claims)
+	}
+
+	// The token is genuine, so a region naming another user pool is a forbidden request rather than a bad token
+	if err := checkRegion(claims, issuers); err != nil {
+		return claims, err
+	}
+
+	if claims.Region == "" {
+		claims.Region = regionForIssuer(issuers, claims.Issuer)
+	}
+
+	return claims, nil
+}
+
+// checkIssuer rejects tokens not issued by a configured user pool
+func checkIssuer(issuer string, issuers map[string]string) error {
+	issuer = strings.TrimSuffix(issuer, "/")
+	for _, trusted := range issuers {
+		if trusted == issuer {
+			return nil
+		}
+	}
+
+	return JSONError{Message: "Untrusted issuer"}
+}
+
+// checkRegion forbids tokens whose region alias has no user pool, or names a different user pool than the one
+// that issued them
+func checkRegion(claims CognitoJWTClaim, issuers map[string]string) error {
+	if claims.Region == "" {
+		return nil
+	}
+
+	expected, ok := issuers[claims.Region]
+	if !ok {
+		return forbidden(fmt.Sprintf("%v: %s", errUnknownRegion, claims.Region))
+	}
+	if expected != strings.TrimSuffix(claims.Issuer, "/") {
+		return forbidden(fmt.Sprintf("Region %s does not match the token issuer", claims.Region))
+	}
+
+	return nil
+}
+
+// checkClient accepts the token when its aud (ID tokens) or client_id (access tokens) is an allowed app client
+func (v *tokenValidator) checkClient(claims CognitoJWTClaim) error {
+	if claims.Audience != "" && v.clientIDs[claims.Audience] {
+		return nil
+	}
+
+	if claims.ClientID != "" && v.clientIDs[claims.ClientID] {
+		return nil
+	}
+
+	return JSONError{Message: "Invalid audience"}
+}
+
+// checkTokenAge rejects tokens older than maxTokenAge, however far away their expiry is
+func (v *tokenValidator) checkTokenAge(claims CognitoJWTClaim) error {
+	if v.maxTokenAge == 0 {
+		return nil
+	}
+
+	if claims.IssuedAt == 0 {
+		return JSONError{Message: "Token has no issue time"}
+	}
+
+	if v.now().Sub(time.Unix(claims.IssuedAt, 0)) > v.maxTokenAge+v.leeway {
+		return JSONError{Message: "Token is too old"}
+	}
+
+	return nil
+}
+
+// checkTokenUse rejects token types that are not accepted by the API
+func (v *tokenValidator) checkTokenUse(claims CognitoJWTClaim, apiID string) error {
+	if claims.TokenUse != tokenUseID && claims.TokenUse != tokenUseAccess {
+		return JSONError{Message: "Invalid token use"}
+	}
+
+	accepted := v.tokenUses
+	if apiTokenUses, ok := v.apiTokenUses[apiID]; ok {
+		accepted = apiTokenUses
+	}
+
+	if !accepted[claims.TokenUse] {
+		return JSONError{Message: "Token use not accepted"}
+	}
+
+	return nil
+}
+
+// resolveAccessTokenAttributes fills in the tenant, role and region of an access token from its cognito:groups,
+// which only administrators can assign
+func (v *tokenValidator) resolveAccessTokenAttributes(claims *CognitoJWTClaim) {
+	for _, group := range claims.Groups {
+		switch {
+		case claims.TenantID == "" && strings.HasPrefix(group, tenantAttributePrefix):
+			claims.TenantID = strings.TrimPrefix(group, tenantAttributePrefix)
+		case claims.UserRole == "" && strings.HasPrefix(group, roleAttributePrefix):
+			claims.UserRole = strings.TrimPrefix(group, roleAttributePrefix)
+		case claims.Region == "" && strings.HasPrefix(group, regionAttributePrefix):
+			claims.Region = strings.TrimPrefix(group, regionAttributePrefix)
+		}
+	}
+}
+
+// regionForIssuer returns the region alias of the issuer, or "" when the issuer is shared by several aliases
+func regionForIssuer(issuers map[string]string, issuer string) string {
+	var region string
+	for alias, trusted := range issuers {
+		if trusted == strings.TrimSuffix(issuer, "/") {
+			if region != "" {
+				return ""
+			}
+			region = alias
+		}
+	}
+	return region
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	testIssuer   = "https://cognito-idp.eu-west-2.amazonaws.com/eu-west-2_test"
+	testClientID = "test-client"
+	testKeyID    = "test-key"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestValidator returns a validator trusting testIssuer, whose JWKS holds the public half of key
+func newTestValidator(t *testing.T, key *rsa.PrivateKey, maxTokenAge time.Duration) *tokenValidator {
+	t.Helper()
+
+	publicKey, err := jwk.New(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := publicKey.Set(jwk.KeyIDKey, testKeyID); err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	set.Add(publicKey)
+
+	keys := newJWKSCache(time.Hour, time.Minute, time.Hour, time.Second)
+	keys.fetch = func(ctx context.Context, url string) (jwk.Set, error) {
+		return set, nil
+	}
+
+	return &tokenValidator{
+		issuers: func() (map[string]string, error) {
+			return map[string]string{"eu1": testIssuer}, nil
+		},
+		clientIDs:    map[string]bool{testClientID: true},
+		tokenUses:    map[string]bool{tokenUseID: true},
+		apiTokenUses: map[string]map[string]bool{},
+		algorithms:   []string{"RS256"},
+		leeway:       30 * time.Second,
+		maxTokenAge:  maxTokenAge,
+		now:          func() time.Time { return testNow },
+		keys:         keys,
+	}
+}
+
+// signTestToken returns an ID token for testIssuer with the given time claims, omitting any that are nil
+func signTestToken(t *testing.T, key *rsa.PrivateKey, exp, nbf, iat *time.Time) string {
+	t.Helper()
+
+	claims := jwt.MapClaims{
+		"iss":              testIssuer,
+		"aud":              testClientID,
+		"sub":              "user-1",
+		"token_use":        tokenUseID,
+		"custom:tenantId":  "tenant-1",
+		"custom:userRole":  "TenantUser",
+		"custom:region":    "eu1",
+		"custom:firstName": "Test",
+	}
+	for name, value := range map[string]*time.Time{"exp": exp, "nbf": nbf, "iat": iat} {
+		if value != nil {
+			claims[name] = value.Unix()
+		}
+	}
+
+	return signTestClaims(t, key, claims)
+}
+
+func signTestClaims(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func at(offset time.Duration) *time.Time {
+	value := testNow.Add(offset)
+	return &value
+}
+
+func TestValidateTimeClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		exp         *time.Time
+		nbf         *time.Time
+		iat         *time.Time
+		maxTokenAge time.Duration
+		wantErr     string
+	}{
+		{name: "valid", exp: at(time.Hour), iat: at(-time.Minute)},
+		{name: "missing exp", iat: at(-time.Minute), wantErr: "Failed to parse token"},
+		{name: "exp within leeway", exp: at(-29 * time.Second), iat: at(-time.Hour)},
+		{name: "exp beyond leeway", exp: at(-31 * time.Second), iat: at(-time.Hour), wantErr: "Token has expired"},
+		{name: "nbf within leeway", exp: at(time.Hour), nbf: at(29 * time.Second)},
+		{name: "nbf beyond leeway", exp: at(time.Hour), nbf: at(31 * time.Second), wantErr: "Token is not valid yet"},
+		{name: "iat in the future within leeway", exp: at(time.Hour), iat: at(29 * time.Second)},
+		{name: "iat in the future beyond leeway", exp: at(time.Hour), iat: at(31 * time.Second), wantErr: "Token is issued in the future"},
+		{name: "max age within leeway", exp: at(time.Hour), iat: at(-15*time.Minute - 29*time.Second), maxTokenAge: 15 * time.Minute},
+		{name: "max age beyond leeway", exp: at(time.Hour), iat: at(-15*time.Minute - 31*time.Second), maxTokenAge: 15 * time.Minute, wantErr: "Token is too old"},
+		{name: "max age without iat", exp: at(time.Hour), maxTokenAge: 15 * time.Minute, wantErr: "Token has no issue time"},
+		{name: "max age disabled", exp: at(time.Hour), iat: at(-24 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := newTestValidator(t, key, tt.maxTokenAge)
+			token := signTestToken(t, key, tt.exp, tt.nbf, tt.iat)
+
+			claims, err := validator.Validate(context.Background(), token, "api")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if claims.TenantID != "tenant-1" {
+					t.Fatalf("Validate() tenant = %q, want tenant-1", claims.TenantID)
+				}
+				return
+			}
+
+			var jsonErr JSONError
+			if !errors.As(err, &jsonErr) || jsonErr.Message != tt.wantErr {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateIssuerAndRegion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const otherIssuer = "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_test"
+
+	tests := []struct {
+		name       string
+		issuer     string
+		region     string
+		signingKey *rsa.PrivateKey
+		wantRegion string
+		wantStatus int
+	}{
+		{name: "matching region", issuer: testIssuer, region: "eu1", wantRegion: "eu1"},
+		{name: "region filled from issuer", issuer: testIssuer, wantRegion: "eu1"},
+		{name: "untrusted issuer", issuer: "https://attacker.example.com", region: "eu1", wantStatus: http.StatusUnauthorized},
+		{name: "region of another user pool", issuer: testIssuer, region: "us1", wantStatus: http.StatusForbidden},
+		{name: "unknown region", issuer: testIssuer, region: "zz9", wantStatus: http.StatusForbidden},
+		{name: "unknown region with a bad signature", issuer: testIssuer, region: "zz9", signingKey: otherKey, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := newTestValidator(t, key, 0)
+			validator.issuers = func() (map[string]string, error) {
+				return map[string]string{"eu1": testIssuer, "us1": otherIssuer}, nil
+			}
+
+			claims := jwt.MapClaims{
+				"iss":             tt.issuer,
+				"aud":             testClientID,
+				"sub":             "user-1",
+				"token_use":       tokenUseID,
+				"custom:tenantId": "tenant-1",
+				"custom:userRole": "TenantUser",
+				"exp":             testNow.Add(time.Hour).Unix(),
+			}
+			if tt.region != "" {
+				claims["custom:region"] = tt.region
+			}
+			signingKey := key
+			if tt.signingKey != nil {
+				signingKey = tt.signingKey
+			}
+
+			got, err := validator.Validate(context.Background(), signTestClaims(t, signingKey, claims), "api")
+			switch tt.wantStatus {
+			case 0:
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if got.Region != tt.wantRegion {
+					t.Fatalf("Validate() region = %q, want %q", got.Region, tt.wantRegion)
+				}
+			case http.StatusUnauthorized:
+				var jsonErr JSONError
+				if !errors.As(err, &jsonErr) {
+					t.Fatalf("Validate() error = %v, want an invalid token", err)
+				}
+			default:
+				var rejection Rejection
+				if !errors.As(err, &rejection) || rejection.Status != tt.wantStatus {
+					t.Fatalf("Validate() error = %v, want status %d", err, tt.wantStatus)
+				}
+			}
+		})
+	}
+}
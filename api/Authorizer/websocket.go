@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// webSocketTokenParameter is the query string parameter carrying the token on $connect (WEBSOCKET_TOKEN_QUERY_PARAMETER)
+var webSocketTokenParameter = envString("WEBSOCKET_TOKEN_QUERY_PARAMETER", "token")
+
+// webSocketToken returns the token from the query string or, for browser clients that cannot set headers on a
+// WebSocket, the JWT offered as one of the Sec-WebSocket-Protocol subprotocols
+func webSocketToken(event events.APIGatewayCustomAuthorizerRequestTypeRequest) string {
+	if token := event.QueryStringParameters[webSocketTokenParameter]; token != "" {
+		return token
+	}
+
+	for _, protocol := range strings.Split(getHeader(event.Headers, "Sec-WebSocket-Protocol"), ",") {
+		protocol = strings.TrimSpace(protocol)
+		if strings.Count(protocol, ".") == 2 {
+			return protocol
+		}
+	}
+
+	return ""
+}
+
+// WebSocketHandler authorizes WebSocket API $connect requests. API Gateway passes the returned context to every route
+// of the connection, where utils.BuildWebSocketExecutionEnvironment reads it.
+func WebSocketHandler(ctx context.Context, event events.APIGatewayCustomAuthorizerRequestTypeRequest) (Response, error) {
+	request := authorizerRequest{
+		RequestID: event.RequestContext.RequestID,
+		APIID:     event.RequestContext.APIID,
+		MethodArn: event.MethodArn,
+		Headers:   event.Headers,
+		Token:     webSocketToken(event),
+		WebSocket: true,
+	}
+
+	policy, rejection, err := evaluate(ctx, request)
+	if err != nil {
+		return Response{}, fmt.Errorf("internal authorizer error: %w", err)
+	}
+	if rejection == nil {
+		return policy, nil
+	}
+
+	if rejection.Status == http.StatusUnauthorized {
+		return Response{}, errUnauthorized
+	}
+
+	return denyResponse("user", request.MethodArn, rejection.Reason), nil
+}
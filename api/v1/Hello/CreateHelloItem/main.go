@@ -64,7 +64,8 @@ func CreateHelloItem(ctx context.Context, request events.APIGatewayProxyRequest)
 	id := ksuid.New().String()
 	item := map[string]*dynamodb.AttributeValue{
 		"PK": {
-			S: aws.String("TENANT#" + execContext.TenantID),
+			// Items are owned by the user creating them, so TenantUser session policies allow the write
+			S: aws.String("TENANT#" + execContext.TenantID + "#USER#" + execContext.UserID),
 		},
 		"SK": {
 			S: aws.String("HELLO#" + id),
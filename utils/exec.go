@@ -21,14 +21,28 @@ type ExecutionContext struct {
 	TenantID  string
 	UserRole  string
 	UserID    string
+	// DynamoDBEndpoint is set when the tenant's region uses a non-default DynamoDB endpoint
+	DynamoDBEndpoint string
 }
 
 func BuildExecutionEnvironment(request events.APIGatewayProxyRequest) (*AuthorizerContext, *ExecutionContext, error) {
+	return buildExecutionEnvironment(request.RequestContext.Authorizer)
+}
+
+// BuildWebSocketExecutionEnvironment extracts the tenant context the authorizer returned on $connect, which API
+// Gateway passes to every route of the WebSocket connection
+func BuildWebSocketExecutionEnvironment(request events.APIGatewayWebsocketProxyRequest) (*AuthorizerContext, *ExecutionContext, error) {
+	authorizer, ok := request.RequestContext.Authorizer.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("authorizer context not found for connection %s", request.RequestContext.ConnectionID)
+	}
+	return buildExecutionEnvironment(authorizer)
+}
+
+func buildExecutionEnvironment(authorizer map[string]interface{}) (*AuthorizerContext, *ExecutionContext, error) {
 	var auth AuthorizerContext
 	var exec ExecutionContext
 
-	authorizer := request.RequestContext.Authorizer
-
 	tenantID, ok := authorizer["tenantId"].(string)
 	if !ok {
 		return nil, nil, fmt.Errorf("tenantId not found in context")
@@ -89,6 +103,9 @@ func BuildExecutionEnvironment(request events.APIGatewayProxyRequest) (*Authoriz
 	}
 	exec.UserID = userID
 
+	// Optional, only present for regions with a custom endpoint
+	exec.DynamoDBEndpoint, _ = authorizer["dynamoDBEndpoint"].(string)
+
 	contextLogger := NewContextLogger(&exec)
 	contextLogger.InfoLog("Authorizer details fetched successfully", Fields{"execution_context": exec})
 
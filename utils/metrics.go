@@ -15,6 +15,11 @@ type APIMetrics interface {
 	RecordError(ctx context.Context, request events.APIGatewayProxyRequest, metricName string)
 }
 
+// AuthorizerMetrics records metrics for the Lambda authorizer, which has no APIGatewayProxyRequest to tag from.
+type AuthorizerMetrics interface {
+	RecordAuthorizerEvent(ctx context.Context, metricName string, tags ...string)
+}
+
 type DataDogMetrics struct{}
 
 func (dd *DataDogMetrics) RecordSuccess(ctx context.Context, request events.APIGatewayProxyRequest, metricName string) {
@@ -27,20 +32,32 @@ func (dd *DataDogMetrics) RecordError(ctx context.Context, request events.APIGat
 	ddlambda.Metric(baseMetricName+metricName+".errors", 1.0, tags...)
 }
 
+func (dd *DataDogMetrics) RecordAuthorizerEvent(ctx context.Context, metricName string, tags ...string) {
+	tags = append(tags, getFunctionTags()...)
+	ddlambda.Metric(baseMetricName+"authorizer."+metricName, 1.0, tags...)
+}
+
 // getCommonTags extracts common tags from the context and request.
 func getCommonTags(ctx context.Context, request events.APIGatewayProxyRequest) []string {
-	functionName := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
-	functionVersion := os.Getenv("AWS_LAMBDA_FUNCTION_VERSION")
-	functionExecutionEnv := os.Getenv("AWS_EXECUTION_ENV")
-	functionMemorySize := os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE")
-	region := os.Getenv("AWS_REGION")
-	return []string{
+	tags := []string{
 		"tenant:" + request.RequestContext.Authorizer["tenantId"].(string),
 		"path:" + request.Path,
 		"method:" + request.HTTPMethod,
 		"stage:" + request.RequestContext.Stage,
 		"request_id:" + request.RequestContext.RequestID,
 		"source_ip:" + request.RequestContext.Identity.SourceIP,
+	}
+	return append(tags, getFunctionTags()...)
+}
+
+// getFunctionTags extracts tags describing the running Lambda function from its environment.
+func getFunctionTags() []string {
+	functionName := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	functionVersion := os.Getenv("AWS_LAMBDA_FUNCTION_VERSION")
+	functionExecutionEnv := os.Getenv("AWS_EXECUTION_ENV")
+	functionMemorySize := os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE")
+	region := os.Getenv("AWS_REGION")
+	return []string{
 		"function_name:" + functionName,
 		"function_version:" + functionVersion,
 		"function_execution_env:" + functionExecutionEnv,
@@ -53,3 +70,8 @@ func getCommonTags(ctx context.Context, request events.APIGatewayProxyRequest) [
 func NewDataDogMetrics() APIMetrics {
 	return &DataDogMetrics{}
 }
+
+// NewDataDogAuthorizerMetrics returns an instance of DataDogMetrics for the Lambda authorizer.
+func NewDataDogAuthorizerMetrics() AuthorizerMetrics {
+	return &DataDogMetrics{}
+}